@@ -0,0 +1,405 @@
+/*
+	Benchmark harness for GO-WARP
+	http://pads.cs.unibo.it
+  
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+
+package main
+
+/*
+ * Runs a model over a matrix of configurations (model parameters files,
+ * number of entities, GOMAXPROCS, number of LPs), each one repeated a given
+ * number of times. Every run is a separate process, its statistics are read
+ * from the JSON file written with the -stats option and summarized in CSV
+ * and JSON tables
+ */
+
+import(
+    "fmt"
+    "flag"
+    "os"
+    "io"
+    "io/ioutil"
+    "exec"
+    "bufio"
+    "json"
+    "strings"
+    "strconv"
+    "math"
+)
+
+const usage = "Bench.out [-model file] [-lps list] [-procs list] [-entities list] [-conf list] [-reps n] [-all] [-out prefix] [-- model options]"
+
+var(
+    model = flag.String("model", "./builds/Main.out", "model executable")
+    lpList = flag.String("lps", "1,2,3,4", "comma separated numbers of LPs")
+    procList = flag.String("procs", "1,2,3,4", "comma separated values of GOMAXPROCS")
+    entList = flag.String("entities", "1000", "comma separated numbers of entities, 0 if the model takes only the LPs")
+    confList = flag.String("conf", "", "comma separated model parameters files, the model default if empty")
+    reps = flag.Int("reps", 5, "repetitions of each configuration")
+    all = flag.Bool("all", false, "run also the configurations with more LPs than GOMAXPROCS")
+    out = flag.String("out", "./logs/bench", "prefix of the CSV and JSON result tables")
+    logdir = flag.String("logs", "./logs/", "directory of the output and statistics of each run")
+)
+
+/* the measures taken from a single run */
+type run struct {
+    Wall float64		// seconds
+    Rate float64		// committed events per second
+    Efficiency float64		// committed / processed events
+    Rollbacks float64
+    Gvt float64			// GVT evaluations
+}
+
+/* a configuration of the matrix and its summarized runs */
+type cell struct {
+    Conf string
+    Entities int
+    Procs int
+    Lps int
+    Ok int
+    Failed int
+    Wall, Rate, Efficiency, Rollbacks, Gvt summary
+    Speedup float64		// with respect to the first cell of the same conf and entities
+    ParEfficiency float64	// speedup divided by the GOMAXPROCS ratio
+}
+
+type summary struct {
+    Mean, Sd, Ci float64	// Ci is the half width of the 95% confidence interval
+}
+
+/* Student t quantiles for the 95% confidence intervals, index is degrees of freedom - 1 */
+var tTable = []float64{12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+    2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+    2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042}
+
+
+func main() {
+    flag.Parse()
+
+    lps := intList(*lpList)
+    procs := intList(*procList)
+    ents := intList(*entList)
+    confs := strings.Split(*confList, ",", -1)
+
+    path,err := exec.LookPath(*model)
+    if err != nil {
+        fmt.Println("GO-WARP, model not found:",err)
+        os.Exit(1)
+    }
+
+    cells := make([]*cell, len(confs)*len(ents)*len(procs)*len(lps))
+    ncells := 0
+    nrun := 0
+    for _,c := range confs {
+        for _,e := range ents {
+            var base *cell = nil
+            for _,p := range procs {
+                for _,l := range lps {
+                    if l > p && !*all {
+                        continue
+                    }
+                    fmt.Println("BENCH: conf",c,"entities",e,"GOMAXPROCS",p,"LPs",l)
+                    runs := make([]run, *reps)
+                    x := &cell{Conf: c, Entities: e, Procs: p, Lps: l}
+                    for k:=0;k<*reps;k++ {
+                        nrun++
+                        r,err := execute(path, c, e, p, l, nrun)
+                        if err != nil {
+                            fmt.Println("BENCH: run",nrun,"failed:",err)
+                            x.Failed++
+                            continue
+                        }
+                        runs[x.Ok] = r
+                        x.Ok++
+                    }
+                    summarize(x, runs[0:x.Ok])
+                    if base == nil {
+                        base = x
+                    }
+                    if x.Ok > 0 && base.Ok > 0 {
+                        x.Speedup = base.Wall.Mean / x.Wall.Mean
+                        x.ParEfficiency = x.Speedup * float64(base.Procs) / float64(x.Procs)
+                    }
+                    cells[ncells] = x
+                    ncells++
+                }
+            }
+        }
+    }
+    cells = cells[0:ncells]
+
+    printTable(os.Stdout, cells)
+    if err := writeCSV(*out+".csv", cells); err != nil {
+        fmt.Println("GO-WARP, error writing the results:",err)
+        os.Exit(1)
+    }
+    if err := writeJSON(*out+".json", cells); err != nil {
+        fmt.Println("GO-WARP, error writing the results:",err)
+        os.Exit(1)
+    }
+}
+
+
+func intList(s string) []int {
+    f := strings.Split(s, ",", -1)
+    v := make([]int, len(f))
+    for i:=0;i<len(f);i++ {
+        n,err := strconv.Atoi(strings.TrimSpace(f[i]))
+        if err != nil {
+            fmt.Println("GO-WARP, bad list",s,":",err)
+            fmt.Println(usage)
+            os.Exit(1)
+        }
+        v[i] = n
+    }
+    return v
+}
+
+
+/* runs the model once, its output goes in <logs>bench.<n>.out */
+func execute(path string, conf string, ent int, procs int, lps int, n int) (r run, err os.Error) {
+    prefix := fmt.Sprintf("%sbench.%d", *logdir, n)
+
+    extra := flag.Args()
+    argv := make([]string, 0, len(extra)+7)
+    argv = argv[0:1]
+    argv[0] = path
+    if conf != "" {
+        argv = argv[0:len(argv)+2]
+        argv[len(argv)-2] = "-conf"
+        argv[len(argv)-1] = conf
+    }
+    argv = argv[0:len(argv)+2]
+    argv[len(argv)-2] = "-stats"
+    argv[len(argv)-1] = prefix
+    for _,a := range extra {
+        argv = argv[0:len(argv)+1]
+        argv[len(argv)-1] = a
+    }
+    argv = argv[0:len(argv)+1]
+    argv[len(argv)-1] = strconv.Itoa(lps)
+    if ent > 0 {
+        argv = argv[0:len(argv)+1]
+        argv[len(argv)-1] = strconv.Itoa(ent)
+    }
+
+    env := os.Environ()
+    envv := make([]string, len(env)+1)
+    ne := 0
+    for i:=0;i<len(env);i++ {
+        if !strings.HasPrefix(env[i], "GOMAXPROCS=") {
+            envv[ne] = env[i]
+            ne++
+        }
+    }
+    envv[ne] = fmt.Sprintf("GOMAXPROCS=%d", procs)
+    envv = envv[0:ne+1]
+
+    logf,err := os.Open(prefix+".out", os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0644)
+    if err != nil {
+        return
+    }
+    defer logf.Close()
+
+    cmd,err := exec.Run(path, argv, envv, "", exec.DevNull, exec.Pipe, exec.MergeWithStdout)
+    if err != nil {
+        return
+    }
+    io.Copy(logf, cmd.Stdout)
+    w,err := cmd.Wait(0)
+    if err != nil {
+        return
+    }
+    if !w.Exited() || w.ExitStatus() != 0 {
+        err = os.NewError(fmt.Sprint("exit status ", w.ExitStatus(), ", see ", prefix+".out"))
+        return
+    }
+
+    return readStats(prefix+".json")
+}
+
+
+/*
+ * reads a statistics file written by Stats.WriteJSON: the wall clock time
+ * and the GVT evaluations of the run, the other measures from its totals
+ */
+func readStats(filename string) (r run, err os.Error) {
+    file,err := os.Open(filename, os.O_RDONLY, 0)
+    if err != nil {
+        return
+    }
+    b,err := ioutil.ReadAll(file)
+    file.Close()
+    if err != nil {
+        return
+    }
+
+    var m map[string]interface{}
+    if err = json.Unmarshal(b, &m); err != nil {
+        err = os.NewError(filename+": "+err.String())
+        return
+    }
+    tot,ok := m["total"].(map[string]interface{})
+    if !ok {
+        err = os.NewError(filename+": missing total")
+        return
+    }
+
+    var v [5]float64
+    objs := []map[string]interface{}{m, m, tot, tot, tot}
+    keys := []string{"wallclock_ns", "gvt_evaluations", "committed", "efficiency", "rollbacks"}
+    for i,k := range keys {
+        x,ok := objs[i][k].(float64)
+        if !ok {
+            err = os.NewError(filename+": missing "+k)
+            return
+        }
+        v[i] = x
+    }
+    r.Wall = v[0]/1e9
+    r.Gvt = v[1]
+    if r.Wall > 0 {
+        r.Rate = v[2]/r.Wall
+    }
+    r.Efficiency = v[3]
+    r.Rollbacks = v[4]
+    return
+}
+
+
+func summarize(x *cell, runs []run) {
+    n := len(runs)
+    v := make([]float64, n)
+
+    get := []func(r *run) float64{
+        func(r *run) float64 { return r.Wall },
+        func(r *run) float64 { return r.Rate },
+        func(r *run) float64 { return r.Efficiency },
+        func(r *run) float64 { return r.Rollbacks },
+        func(r *run) float64 { return r.Gvt },
+    }
+    dst := sums(x)
+    for i:=0;i<len(get);i++ {
+        for j:=0;j<n;j++ {
+            v[j] = get[i](&runs[j])
+        }
+        *dst[i] = stats(v)
+    }
+}
+
+
+/* mean, sample standard deviation and 95% confidence interval half width */
+func stats(v []float64) summary {
+    var s summary
+    n := len(v)
+
+    if n == 0 {
+        return s
+    }
+    for i:=0;i<n;i++ {
+        s.Mean += v[i]
+    }
+    s.Mean /= float64(n)
+    if n < 2 {
+        return s
+    }
+    for i:=0;i<n;i++ {
+        s.Sd += (v[i]-s.Mean)*(v[i]-s.Mean)
+    }
+    s.Sd = math.Sqrt(s.Sd/float64(n-1))
+
+    t := 1.96
+    if n-1 <= len(tTable) {
+        t = tTable[n-2]
+    }
+    s.Ci = t*s.Sd/math.Sqrt(float64(n))
+    return s
+}
+
+
+func printTable(w io.Writer, cells []*cell) {
+    fmt.Fprintf(w, "\n%-16s %8s %6s %4s %4s %12s %12s %14s %8s %8s %8s\n", "conf", "entities", "procs", "LPs",
+        "runs", "wall(s)", "+-ci", "events/s", "TW eff", "speedup", "par eff")
+    for _,x := range cells {
+        c := x.Conf
+        if c == "" {
+            c = "default"
+        }
+        fmt.Fprintf(w, "%-16s %8d %6d %4d %4d %12.3f %12.3f %14.1f %8.3f %8.3f %8.3f\n", c, x.Entities, x.Procs, x.Lps,
+            x.Ok, x.Wall.Mean, x.Wall.Ci, x.Rate.Mean, x.Efficiency.Mean, x.Speedup, x.ParEfficiency)
+    }
+}
+
+
+func create(filename string) (*os.File, os.Error) {
+    return os.Open(filename, os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0644)
+}
+
+
+var names = []string{"wall_s", "rate", "efficiency", "rollbacks", "gvt"}
+
+
+func sums(x *cell) []*summary {
+    return []*summary{&x.Wall, &x.Rate, &x.Efficiency, &x.Rollbacks, &x.Gvt}
+}
+
+
+func writeCSV(filename string, cells []*cell) os.Error {
+    file,err := create(filename)
+    if err != nil {
+        return err
+    }
+    w := bufio.NewWriter(file)
+
+    fmt.Fprint(w, "conf,entities,procs,lps,runs,failed")
+    for _,n := range names {
+        fmt.Fprintf(w, ",%s_mean,%s_sd,%s_ci95", n, n, n)
+    }
+    fmt.Fprint(w, ",speedup,par_efficiency\n")
+    for _,x := range cells {
+        fmt.Fprintf(w, "%s,%d,%d,%d,%d,%d", x.Conf, x.Entities, x.Procs, x.Lps, x.Ok, x.Failed)
+        for _,s := range sums(x) {
+            fmt.Fprintf(w, ",%g,%g,%g", s.Mean, s.Sd, s.Ci)
+        }
+        fmt.Fprintf(w, ",%g,%g\n", x.Speedup, x.ParEfficiency)
+    }
+
+    err = w.Flush()
+    file.Close()
+    return err
+}
+
+
+func writeJSON(filename string, cells []*cell) os.Error {
+    file,err := create(filename)
+    if err != nil {
+        return err
+    }
+    w := bufio.NewWriter(file)
+
+    fmt.Fprint(w, "[\n")
+    for i,x := range cells {
+        fmt.Fprintf(w, "  {\"conf\": %s, \"entities\": %d, \"procs\": %d, \"lps\": %d, \"runs\": %d, \"failed\": %d",
+            strconv.Quote(x.Conf), x.Entities, x.Procs, x.Lps, x.Ok, x.Failed)
+        for j,s := range sums(x) {
+            fmt.Fprintf(w, ", \"%s\": {\"mean\": %g, \"sd\": %g, \"ci95\": %g}", names[j], s.Mean, s.Sd, s.Ci)
+        }
+        fmt.Fprintf(w, ", \"speedup\": %g, \"par_efficiency\": %g}", x.Speedup, x.ParEfficiency)
+        if i < len(cells)-1 { fmt.Fprint(w, ",") }
+        fmt.Fprint(w, "\n")
+    }
+    fmt.Fprint(w, "]\n")
+
+    err = w.Flush()
+    file.Close()
+    return err
+}
@@ -0,0 +1,82 @@
+/*
+	Model conformance tool for GO-WARP
+	http://pads.cs.unibo.it
+  
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+
+package main
+
+/*
+ * Checks that a model is rollback-safe with the Conformance kit: its
+ * Time Warp runs must commit the same events as the 1-LP Time Warp
+ * reference run. The exit status is 0 if the model conforms
+ */
+
+import(
+    "../src/Conformance"
+    "fmt"
+    "flag"
+    "os"
+    "strings"
+    "strconv"
+)
+
+const usage = "Conform.out [-model file] [-lps list] [-procs list] [-rollbacks p] [-faults spec] [-dir dir] [-q] -- model arguments (%lp for the LPs)"
+
+var(
+    model = flag.String("model", "./builds/Main.out", "model executable")
+    lpList = flag.String("lps", "1,2,4", "comma separated numbers of LPs")
+    procList = flag.String("procs", "1,4", "comma separated values of GOMAXPROCS")
+    rollbacks = flag.Float64("rollbacks", 0.01, "probability of the forced rollbacks, 0 to skip those runs")
+    faults = flag.String("faults", "delay=0.3,hold=10,batch=3,reorder,antifirst", "fault injection parameters, empty to skip those runs")
+    dir = flag.String("dir", "./logs/", "directory of the logs of the runs")
+    quiet = flag.Bool("q", false, "prints only the result")
+)
+
+
+func main() {
+    flag.Parse()
+    if flag.NArg() == 0 {
+        fmt.Println(usage)
+        os.Exit(2)
+    }
+
+    c := &Conformance.Config{
+        Model: *model,
+        Args: flag.Args(),
+        Lps: intList(*lpList),
+        Procs: intList(*procList),
+        Rollbacks: *rollbacks,
+        Faults: *faults,
+        Dir: *dir,
+        Verbose: !*quiet,
+    }
+    if err := Conformance.Check(c); err != nil {
+        fmt.Println("CONFORMANCE: FAILED,",err)
+        os.Exit(1)
+    }
+    fmt.Println("CONFORMANCE: OK")
+}
+
+
+func intList(s string) []int {
+    f := strings.Split(s, ",", -1)
+    v := make([]int, len(f))
+    for i:=0;i<len(f);i++ {
+        n,err := strconv.Atoi(strings.TrimSpace(f[i]))
+        if err != nil {
+            fmt.Println("CONFORMANCE, bad list",s,":",err)
+            os.Exit(2)
+        }
+        v[i] = n
+    }
+    return v
+}
@@ -0,0 +1,136 @@
+/*
+	A model that is not rollback-safe, for the tests of the Conformance kit
+	http://pads.cs.unibo.it
+
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+package main
+
+/*
+ * A ring of entities passing tokens. The timestamp and the destination of
+ * each new event depend on a counter of the executed events of the LP
+ * that is not in the model state: it is not saved, a rollback does not
+ * restore it and the re-executed events differ from the first execution.
+ * A single LP never rolls back, so the Conformance kit must find the
+ * first committed event that differs from the reference
+ */
+
+import(
+    "../src/DT"
+    "../src/Sim"
+    "../src/Local"
+    "../src/Stats"
+    "../src/Shared"
+    "../src/CommitLog"
+    "fmt"
+    "flag"
+    "os"
+    "strconv"
+)
+
+const(
+    usage="Unsafe.out [-stats prefix] [-commitlog file] [-rollbacks p] [-faults spec] #LPs"
+    ENTITIES=16
+    ENDTIME=500
+)
+
+var(
+    lpnum int
+    executed []int		// by LP, the unsaved state
+
+    kernel = Sim.KernelFlags()
+    statsfile = flag.String("stats", "logs/stats", "prefix of the JSON and CSV statistics files")
+    commitfile = flag.String("commitlog", "", "canonical log of the committed events, disabled if empty")
+)
+
+
+/* the saved state is only the identifiers counter, see Sim.NewId */
+type unsafeState struct {
+    NextId int32
+}
+
+
+func (s *unsafeState) Copy() DT.LPstate {
+    return &unsafeState{s.NextId}
+}
+
+
+func main() {
+    flag.Parse()
+    if flag.NArg() != 1 {
+        fmt.Println(usage)
+        os.Exit(1)
+    }
+    lpnum,_ = strconv.Atoi(flag.Arg(0))
+    executed = make([]int, lpnum)
+
+    model := &Sim.Model{
+        Process: process,
+        Setup: setup,
+        Init: initLP,
+    }
+    res := Sim.Run(kernel.Config(lpnum, ENDTIME), model)
+    if !res.Started || res.Err != nil {
+        fmt.Println("UNSAFE, error:",res.Err)
+        os.Exit(1)
+    }
+    if err := CommitLog.Close(); err != nil {
+        fmt.Println("UNSAFE, error writing the committed events log:",err)
+        os.Exit(1)
+    }
+    if err := Stats.WriteJSON(*statsfile+".json", res.WallClock, res.Gvts); err != nil {
+        fmt.Println("UNSAFE, error writing the statistics:",err)
+        os.Exit(1)
+    }
+    fmt.Println("UNSAFE: committed events",res.Total.Committed)
+}
+
+
+func owner(e int) int {
+    return Sim.BlockOwner(e, ENTITIES, lpnum)
+}
+
+
+func setup() os.Error {
+    Shared.EntityMap = func(e int) DT.Pid {
+        if e < 0 || e >= ENTITIES {
+            return -1
+        }
+        return DT.Pid(owner(e))
+    }
+    if *commitfile != "" {
+        return CommitLog.Setup(lpnum, *commitfile)
+    }
+    return nil
+}
+
+
+/* a token per entity, the initial events take the identifiers [0, ENTITIES) */
+func initLP(data *Local.LocalData) os.Error {
+    data.LpState = &unsafeState{0}
+    for e:=0;e<ENTITIES;e++ {
+        if owner(e) == int(data.IndexLP) {
+            data.FutureEvents.Insert(DT.CreateEvent(int32(e), DT.Time(1+e%3), DT.Info{e, e, 0}))
+        }
+    }
+    return nil
+}
+
+
+func process(ev *DT.Event, l *Local.LocalData) {
+    s := l.LpState.(*unsafeState)
+    n := executed[l.IndexLP]
+    executed[l.IndexLP]++
+
+    dest := (ev.Type.To + 1 + n%3) % ENTITIES
+    t := ev.Time + 1 + DT.Time(n%4)
+    newev := DT.CreateEvent(Sim.NewId(ENTITIES, &s.NextId, l), t, DT.Info{ev.Type.To, dest, 0})
+    Sim.NoticeEvent(newev, DT.Pid(owner(dest)), l)
+}
@@ -0,0 +1,447 @@
+/*
+	PCS (Personal Communication Services) model for GO-WARP
+	http://pads.cs.unibo.it
+  
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+package main
+
+/*
+ * A wireless network on a torus of cells, each one with a fixed number of
+ * channels. New calls arrive at every cell, a call that finds no free
+ * channel is blocked. A portable moves to one of the four neighbor cells
+ * during its call (handoff) and the call is dropped if the new cell has no
+ * free channel. The cells are the entities, each LP simulates a block of
+ * rows so the handoffs to the cells above and below a block are remote.
+ * The remaining duration of a call is drawn again at each handoff, as the
+ * call durations are memoryless when exponential
+ */
+
+import( 
+    "../src/DT"
+    "../src/Sim"
+    "../src/Random"
+    "../src/Local"
+    "../src/Stats"
+    "../src/CommitLog"
+    "fmt"
+    "flag"
+    "os"
+    "bufio"
+    "strings"
+    "strconv"
+    "runtime"
+)
+
+const(
+    usage="PCS.out [-conf file] [-seed n] [-rng lcg|mrg32k3a] [-stats prefix] [-commitlog file] [-rollbacks p] [-faults spec] [-check] [-sched spec] [-realtime scale [-rtwindow w]] [-timing] #LPs"
+
+    /* event types, in the Flag field */
+    NEXTCALL = 1	// a new call arrives at the cell
+    COMPLETE = 2	// a call ends
+    LEAVE = 3		// the portable of a call leaves the cell
+    HANDOFF = 4		// the portable of a call enters the cell
+
+    NCOUNTERS = 5
+)
+
+/* per cell counters */
+const(
+    CALLS = iota
+    BLOCKED = iota
+    HANDOFFS = iota
+    DROPPED = iota
+    COMPLETED = iota
+)
+
+var(
+    lpnum int
+    rows int
+    cols int
+    ncells int
+    channels int32
+    endtime DT.Time		// in clock ticks
+    scale float64 = 1		// clock ticks per time unit
+    interarrival *Random.Dist	// time between two new calls in a cell
+    duration *Random.Dist	// call duration
+    residence *Random.Dist	// time spent by a portable in a cell
+
+    counterNames = [NCOUNTERS]string{"calls", "blocked", "handoffs", "dropped", "completed"}
+
+    conffile = flag.String("conf", "./PCS/pcs.conf", "network configuration file")
+    kernel = Sim.KernelFlags()	// -seed, -rollbacks, -faults, -check, -sched, -realtime, -rtwindow, -timing, -rng
+    statsfile = flag.String("stats", "logs/pcs", "prefix of the statistics files")
+    commitfile = flag.String("commitlog", "", "canonical log of the committed events, disabled if empty")
+)
+
+
+func main() {
+    flag.Parse()
+    if flag.NArg() != 1 {
+        fmt.Println(usage)
+        os.Exit(1)
+    }
+    lpnum,_ = strconv.Atoi(flag.Arg(0))
+
+    readConf(*conffile)
+    if lpnum < 1 || lpnum > ncells {
+        fmt.Println("GO-WARP, the number of LPs must be between 1 and the number of cells")
+        os.Exit(1)
+    }
+    /* a stream per entity, plus stream 0 */
+    if err := Random.Setup(*kernel.Generator, int64(ncells)+1); err != nil {
+        fmt.Println("GO-WARP,",err)
+        os.Exit(1)
+    }
+
+    config := kernel.Config(lpnum, endtime)
+
+    /* the first call of each cell is drawn from stream 0, the cell streams start from 1 */
+    rng := Random.RandStream(*kernel.Seed, 0)
+    model := &Sim.Model{
+        Process: ProcessEvent,
+        Setup: setup,
+        Init: func(data *Local.LocalData) os.Error {
+            initLP(data, rng)
+            return nil
+        },
+    }
+    res := Sim.Run(config, model)
+    if !res.Started {
+        fmt.Println("GO-WARP, error in the setup:",res.Err)
+        os.Exit(1)
+    }
+    if res.Err != nil {
+        /* the scheduler failures (step limit, deadlock) end the run with exit status 3 */
+        fmt.Println("GO-WARP, deterministic mode:",res.Err)
+        os.Exit(3)
+    }
+    wall := res.WallClock
+    checked := res.CheckReport()
+
+    if err := CommitLog.Close(); err != nil {
+        fmt.Println("GO-WARP, error writing the committed events log:",err)
+    }
+    fmt.Println("SIMULATION IS COMPLETED: TIME REACHED VALUE",endtime)
+    fmt.Println("Wall Clock Time spent (ms):",float64(wall)/1e6)
+    tot := res.Total
+    fmt.Println("Total number of committed events:",tot.Committed)
+    fmt.Println("Efficiency:",tot.Efficiency())
+    if err := Stats.WriteJSON(*statsfile+".json", wall, res.Gvts); err != nil {
+        fmt.Println("GO-WARP, error writing the statistics:",err)
+    }
+    if err := Stats.WriteCSV(*statsfile+".csv"); err != nil {
+        fmt.Println("GO-WARP, error writing the statistics:",err)
+    }
+    report(*statsfile+".cells.csv", res.Datas)
+    if !checked {
+        os.Exit(1)
+    }
+}
+
+
+/* the kernel is set up, the LPs are not created yet */
+func setup() os.Error {
+    if *commitfile != "" {
+        if err := CommitLog.Setup(lpnum, *commitfile); err != nil {
+            return os.NewError("creating the committed events log: "+err.String())
+        }
+    }
+
+    fmt.Println("GO-WARP: the simulator will use",runtime.GOMAXPROCS(-1),"COREs")
+    fmt.Println("GO-WARP: the simulation will use",lpnum,"LPs")
+    return nil
+}
+
+
+/*
+ * pcs.conf holds "key values" lines, everything after # is a comment:
+ * rows n, cols n, channels n, endtime t, scale ticks, interarrival dist,
+ * duration dist, residence dist
+ */
+func readConf(filename string) {
+    var rdErr os.Error
+    var line string
+    var end float64
+
+    file,err := os.Open(filename, os.O_RDONLY,0)
+    if err != nil {
+        fmt.Println("GO-WARP, error opening the network configuration file:",err)
+        os.Exit(1)
+    }
+    rd := bufio.NewReader(file)
+
+    for n:=1;rdErr == nil;n++ {
+        line, rdErr = rd.ReadString('\n')
+        f := strings.Fields(strings.Split(line,"#",2)[0])
+        if len(f) == 0 {
+            continue
+        }
+
+        var err os.Error
+        var c int
+        if len(f) < 2 {
+            fmt.Printf("GO-WARP, error in %s line %d: %s wants a value\n",filename,n,f[0])
+            os.Exit(1)
+        }
+        switch f[0] {
+            case "rows":
+            rows,err = strconv.Atoi(f[1])
+            case "cols":
+            cols,err = strconv.Atoi(f[1])
+            case "channels":
+            c,err = strconv.Atoi(f[1])
+            channels = int32(c)
+            case "endtime":
+            end,err = strconv.Atof64(f[1])
+            case "scale":
+            scale,err = strconv.Atof64(f[1])
+            case "interarrival":
+            interarrival,err = Random.ParseDist(f[1:])
+            case "duration":
+            duration,err = Random.ParseDist(f[1:])
+            case "residence":
+            residence,err = Random.ParseDist(f[1:])
+            default:
+            err = os.NewError("unknown parameter "+f[0])
+        }
+        if err != nil {
+            fmt.Printf("GO-WARP, error in %s line %d: %s\n",filename,n,err)
+            os.Exit(1)
+        }
+    }
+    file.Close()
+
+    ncells = rows*cols
+    endtime = DT.Time(end*scale)
+    if ncells == 0 || channels == 0 || endtime == 0 || interarrival == nil || duration == nil || residence == nil {
+        fmt.Println("GO-WARP, all the parameters must be set in",filename)
+        os.Exit(1)
+    }
+    fmt.Println("GO-WARP: read from file:",rows,"x",cols,"cells,",channels,"channels, endtime",end,"scale",scale)
+    fmt.Println("GO-WARP: interarrival",interarrival,"duration",duration,"residence",residence)
+}
+
+
+func initLP(data *Local.LocalData, rng *Random.RNG) {
+    first,count := Sim.BlockRange(int(data.IndexLP), ncells, lpnum)
+    data.LpState = newState(count)
+
+    for i:=first;i<first+count;i++ {
+        data.NewEvent(DT.CreateEvent(int32(i), ticks(interarrival, rng), DT.Info{i,i,NEXTCALL}))
+    }
+}
+
+
+/* the channels and the counters of the cells of the LP */
+type pcsState struct {
+    NextId int32
+    Free []int32
+    Count [NCOUNTERS][]int32
+}
+
+
+func newState(n int) *pcsState {
+    s := &pcsState{0, make([]int32, n), [NCOUNTERS][]int32{}}
+    for i:=0;i<n;i++ {
+        s.Free[i] = channels
+    }
+    for j:=0;j<NCOUNTERS;j++ {
+        s.Count[j] = make([]int32, n)
+    }
+    return s
+}
+
+
+func (s *pcsState) Copy() DT.LPstate {
+    c := &pcsState{s.NextId, make([]int32, len(s.Free)), [NCOUNTERS][]int32{}}
+    copy(c.Free, s.Free)
+    for j:=0;j<NCOUNTERS;j++ {
+        c.Count[j] = make([]int32, len(s.Free))
+        copy(c.Count[j], s.Count[j])
+    }
+    return c
+}
+
+
+/* the id counter followed by free channels and counters of each cell */
+func (s *pcsState) Marshal() []byte {
+    str := strconv.Itoa(int(s.NextId))
+    for i:=0;i<len(s.Free);i++ {
+        str += " "+strconv.Itoa(int(s.Free[i]))
+        for j:=0;j<NCOUNTERS;j++ {
+            str += " "+strconv.Itoa(int(s.Count[j][i]))
+        }
+    }
+    return []byte(str)
+}
+
+
+func (s *pcsState) Unmarshal(b []byte) os.Error {
+    f := strings.Fields(string(b))
+    if len(f) != 1+len(s.Free)*(NCOUNTERS+1) {
+        return os.NewError("wrong number of cells in the state")
+    }
+    v := make([]int32, len(f))
+    for k:=0;k<len(f);k++ {
+        n,err := strconv.Atoi(f[k])
+        if err != nil {
+            return err
+        }
+        v[k] = int32(n)
+    }
+    s.NextId = v[0]
+    k := 1
+    for i:=0;i<len(s.Free);i++ {
+        s.Free[i] = v[k]
+        k++
+        for j:=0;j<NCOUNTERS;j++ {
+            s.Count[j][i] = v[k]
+            k++
+        }
+    }
+    return nil
+}
+
+
+func ProcessEvent(ev *DT.Event, l *Local.LocalData) {
+    s := l.LpState.(*pcsState)
+    i := ev.Type.To
+    first,_ := Sim.BlockRange(int(l.IndexLP), ncells, lpnum)
+    k := i-first
+
+    switch ev.Type.Flag {
+        case NEXTCALL:
+        s.Count[CALLS][k]++
+        if s.Free[k] > 0 {
+            s.Free[k]--
+            startCall(ev.Time, i, l)
+        } else {
+            s.Count[BLOCKED][k]++
+        }
+        send(ev.Time+ticks(interarrival, l.Rng), i, i, NEXTCALL, l)
+
+        case COMPLETE:
+        s.Free[k]++
+        s.Count[COMPLETED][k]++
+
+        case LEAVE:
+        s.Free[k]++
+        send(ev.Time, i, neighbor(i, l.Rng), HANDOFF, l)
+
+        case HANDOFF:
+        s.Count[HANDOFFS][k]++
+        if s.Free[k] > 0 {
+            s.Free[k]--
+            startCall(ev.Time, i, l)
+        } else {
+            s.Count[DROPPED][k]++
+        }
+    }
+}
+
+
+/* a call takes a channel of cell i at time t: it ends or moves, whatever comes first */
+func startCall(t DT.Time, i int, l *Local.LocalData) {
+    end := ticks(duration, l.Rng)
+    move := ticks(residence, l.Rng)
+    if end <= move {
+        send(t+end, i, i, COMPLETE, l)
+    } else {
+        send(t+move, i, i, LEAVE, l)
+    }
+}
+
+
+func send(t DT.Time, from int, to int, typ int32, l *Local.LocalData) {
+    /* the initial events use the identifiers [0, ncells) */
+    ev := DT.CreateEvent(Sim.NewId(ncells, &l.LpState.(*pcsState).NextId, l), t, DT.Info{from,to,typ})
+    Sim.NoticeEvent(ev, DT.Pid(Sim.BlockOwner(to, ncells, lpnum)), l)
+}
+
+
+/* a value of d in clock ticks, at least one */
+func ticks(d *Random.Dist, rng *Random.RNG) DT.Time {
+    t := DT.Time(d.Draw(rng)*scale + 0.5)
+    if t < 1 {
+        t = 1
+    }
+    return t
+}
+
+
+/* one of the four neighbors of cell i on the torus */
+func neighbor(i int, rng *Random.RNG) int {
+    r := i / cols
+    c := i % cols
+
+    switch rng.RandIntUniform(0, 3) {
+        case 0:
+        r = (r+rows-1) % rows
+        case 1:
+        r = (r+1) % rows
+        case 2:
+        c = (c+cols-1) % cols
+        case 3:
+        c = (c+1) % cols
+    }
+    return r*cols + c
+}
+
+
+/*
+ * the counters in the final states of the LPs cover exactly the events
+ * before the end time, they are printed and written one row per cell
+ */
+func report(filename string, datas []*Local.LocalData) {
+    var tot [NCOUNTERS]int64
+
+    file,err := os.Open(filename, os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0644)
+    if err != nil {
+        fmt.Println("GO-WARP, error writing the cell statistics:",err)
+        return
+    }
+    w := bufio.NewWriter(file)
+    fmt.Fprint(w, "cell,row,col")
+    for j:=0;j<NCOUNTERS;j++ {
+        fmt.Fprint(w, ",", counterNames[j])
+    }
+    fmt.Fprint(w, ",blocking,dropping\n")
+
+    for lp:=0;lp<lpnum;lp++ {
+        s := datas[lp].LpState.(*pcsState)
+        first,count := Sim.BlockRange(lp, ncells, lpnum)
+        for k:=0;k<count;k++ {
+            i := first+k
+            fmt.Fprintf(w, "%d,%d,%d", i, i/cols, i%cols)
+            for j:=0;j<NCOUNTERS;j++ {
+                fmt.Fprintf(w, ",%d", s.Count[j][k])
+                tot[j] += int64(s.Count[j][k])
+            }
+            fmt.Fprintf(w, ",%g,%g\n", ratio(int64(s.Count[BLOCKED][k]), int64(s.Count[CALLS][k])),
+                ratio(int64(s.Count[DROPPED][k]), int64(s.Count[HANDOFFS][k])))
+        }
+    }
+    w.Flush()
+    file.Close()
+
+    fmt.Println("Calls:",tot[CALLS],"blocked:",tot[BLOCKED],"completed:",tot[COMPLETED])
+    fmt.Println("Handoffs:",tot[HANDOFFS],"dropped:",tot[DROPPED])
+    fmt.Println("Blocking probability:",ratio(tot[BLOCKED], tot[CALLS]))
+    fmt.Println("Handoff dropping probability:",ratio(tot[DROPPED], tot[HANDOFFS]))
+}
+
+
+func ratio(a int64, b int64) float64 {
+    if b == 0 {
+        return 0
+    }
+    return float64(a)/float64(b)
+}
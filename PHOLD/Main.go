@@ -19,20 +19,26 @@ import(
     "../src/Shared"
     "../src/Random"
     "../src/Local"
+    "../src/Stats"
+    "../src/Metrics"
+    "../src/Control"
+    "../src/Inject"
+    "../src/Workload"
+    "../src/Trace"
+    "../src/CommitLog"
+    "../src/Checkpoint"
+    "../src/Communication"
     "fmt"
     "flag"
     "os"
     "bufio"
     "strings"
     "strconv"
-    "time"
     "runtime"
-    "sync"
 )
 
 const(
-    usage="Main.out #LPs [if 0 -> autoconf] #ENTITIES"
-    conf="./PHOLD/phold.conf"
+    usage="Main.out [-conf file] [-stats prefix] [-metrics addr [-control]] [-trace file] [-commitlog file] [-checkpoint prefix -checkpoint-at T [-checkpoint-every P]] [-resume prefix] [-rollbacks p] [-faults spec] [-check] [-sched spec] [-realtime scale [-rtwindow w]] [-inject file] [-workload file] [-stop-after n] [-log level] [-lplogs] [-timing] #LPs [if 0 -> autoconf] #ENTITIES"
     cpufile="/proc/cpuinfo"
     cpustr="processor"
 )
@@ -46,17 +52,38 @@ var(
     nFPops int
     randGen *Random.RNG
 
-    initEv []DT.Event
-
-    idcount int32
+    /* model parameters, see phold.conf */
+    remote float64 = -1		// probability of a remote destination, < 0 for a uniform choice
+    lookahead DT.Time = 1		// minimum timestamp increment
+    increment = &Random.Dist{"exponential", []float64{Random.LAMBDA}}	// added to lookahead
+    nhot int = 0			// hot-spot entities are [0, nhot)
+    hotprob float64 = 0		// probability that the destination is a hot-spot entity
+    heavylp int = 0		// the LPs [0, heavylp) run heavier events
+    heavyfactor float64 = 1
+    fpcost *Random.Dist = nil	// FP operations per event, nFPops if nil
+    statesize int = 0		// bytes of model state of each LP
 
-    startT int64
-    endT int64
-    simterm bool
-    n_term int
-    print sync.Mutex
+    initEv []DT.Event
 
    n_cores int
+
+    kernel = Sim.KernelFlags()	// -seed, -rollbacks, -faults, -check, -sched, -realtime, -rtwindow, -timing, -rng
+    conffile = flag.String("conf", "./PHOLD/phold.conf", "PHOLD configuration file")
+    replication = flag.Int64("replication", 0, "replication number, it selects the substream of every stream (mrg32k3a only)")
+    statsfile = flag.String("stats", "logs/stats", "prefix of the JSON and CSV statistics files")
+    commitfile = flag.String("commitlog", "", "canonical log of the committed events, disabled if empty")
+    tracefile = flag.String("trace", "", "Chrome trace-event file of the run, disabled if empty")
+    ckptfile = flag.String("checkpoint", "", "checkpoint files prefix, disabled if empty")
+    ckptat = flag.Int("checkpoint-at", 0, "simulated time of the first checkpoint")
+    ckptevery = flag.Int("checkpoint-every", 0, "period of the checkpoints, 0 for a single one")
+    resumefile = flag.String("resume", "", "resume from the checkpoint with this prefix")
+    metricsaddr = flag.String("metrics", "", "address of the live metrics endpoint (e.g. :8080), disabled if empty")
+    control = flag.Bool("control", false, "serves also the control requests (pause, resume, ...) on the metrics endpoint")
+    inject = flag.String("inject", "", "file of the events to inject while running, - for stdin, disabled if empty")
+    workload = flag.String("workload", "", "CSV or JSON lines file of the initial events, generated if empty")
+    stopafter = flag.Int64("stop-after", 0, "stops the run when this many events are committed, 0 for the end time only")
+    loglevel = flag.String("log", "info", "level of the kernel messages: debug (if built with Log.DEBUG), info, warn or error")
+    lplogs = flag.Bool("lplogs", false, "writes the kernel messages of each LP in logs/lp.<LP>.log")
 )
 
 
@@ -65,19 +92,127 @@ func main() {
 
     initPhold(n_lp, n_ent)
 
-    fmt.Println("GO-WARP: the simulator will use",runtime.GOMAXPROCS(-1),"COREs")
-    fmt.Println("GO-WARP: the simulation will use",n_lp,"LPs")
+    config := kernel.Config(lpnum, endtime)
+    config.LogLevel = *loglevel
+    config.LogFiles = *lplogs
+    model := &Sim.Model{
+        Process: ProcessEvent,
+        Setup: setupPhold,
+        Init: initLP,
+        Finalize: terminate,
+    }
+    res := Sim.Run(config, model)
+    if !res.Started {
+        fmt.Println("GO-WARP, error in the setup:",res.Err)
+        os.Exit(1)
+    }
+    if res.Err != nil {
+        /* the scheduler failures (step limit, deadlock) end the run with exit status 3 */
+        fmt.Println("GO-WARP, deterministic mode:",res.Err)
+        os.Exit(3)
+    }
+
+    checked := res.CheckReport()
+    if err := Trace.Close(); err != nil {
+        fmt.Println("GO-WARP, error writing the trace:",err)
+    }
+    if err := CommitLog.Close(); err != nil {
+        fmt.Println("GO-WARP, error writing the committed events log:",err)
+    }
+    printStats(res)
+    if !checked {
+        os.Exit(1)
+    }
+}
+
+
+/* the kernel is set up, the LPs are not created yet */
+func setupPhold() os.Error {
+    Shared.EntityMap = func(e int) DT.Pid {
+        if e < 0 || e >= entitynum {
+            return -1
+        }
+        return DT.Pid(Sim.BlockOwner(e, entitynum, lpnum))
+    }
+    if *stopafter > 0 {
+        Control.StopWhen(func(gvt DT.Time, committed int64) bool {
+            return committed >= *stopafter
+        })
+    }
 
-    startT = time.Nanoseconds()
-    for i:=1;i<n_lp;i++ {
-        go launchLP(DT.Pid(i), n_ent/n_lp)
+    if *workload != "" {
+        n,err := Workload.Load(*workload)
+        if err != nil {
+            return os.NewError("reading the workload: "+err.String())
+        }
+        fmt.Println("GO-WARP: read",n,"initial events from",*workload)
+        n_events = 0
+        initEv = nil
     }
-    launchLP(0,n_ent/n_lp)
 
-    for (n_term != lpnum) {
-        time.Sleep(1e3)
+    /* the initial events are generated by stream 0, entity streams start from 1 */
+    for i:=0;i<n_events;i++ {
+        e := generateEvent(nil, randGen, int32(i))
+        initEv[i] = *e
+    }
+
+    if *metricsaddr != "" {
+        if err := Metrics.Start(*metricsaddr); err != nil {
+            return os.NewError("starting the metrics endpoint: "+err.String())
+        }
+        fmt.Println("GO-WARP: live metrics on",*metricsaddr+Metrics.PATH)
+        if *control {
+            Control.Handle()
+            fmt.Println("GO-WARP: simulation control on",*metricsaddr+Control.PATH)
+        }
+    }
+    if *ckptfile != "" {
+        Shared.CheckpointFile = *ckptfile
+        Shared.CheckpointTime = DT.Time(*ckptat)
+        Shared.CheckpointPeriod = DT.Time(*ckptevery)
+    }
+    if *commitfile != "" {
+        if err := CommitLog.Setup(lpnum, *commitfile); err != nil {
+            return os.NewError("creating the committed events log: "+err.String())
+        }
+    }
+    if *tracefile != "" {
+        if err := Trace.Setup(lpnum, *tracefile); err != nil {
+            return os.NewError("creating the trace: "+err.String())
+        }
+    }
+    if *inject != "" {
+        if err := Inject.Open(); err != nil {
+            return os.NewError("opening the injector: "+err.String())
+        }
+        go runInjector(*inject)
+    }
+
+    fmt.Println("GO-WARP: the simulator will use",runtime.GOMAXPROCS(-1),"COREs")
+    fmt.Println("GO-WARP: the simulation will use",lpnum,"LPs")
+    return nil
+}
+
+
+/*
+ * injects the events read from filename (- for stdin), the run ends only
+ * after the end of the input
+ */
+func runInjector(filename string) {
+    file := os.Stdin
+    if filename != "-" {
+        var err os.Error
+        file,err = os.Open(filename, os.O_RDONLY, 0)
+        if err != nil {
+            fmt.Println("GO-WARP, error opening the events to inject:",err)
+            Inject.Close()
+            return
+        }
+        defer file.Close()
+    }
+    if err := Inject.Run(file); err != nil {
+        fmt.Println("GO-WARP, event injection stopped:",err)
     }
-    printStats(endT)
 }
 
 
@@ -125,136 +260,287 @@ func getNCpu() int {
 
 
 func initPhold(nlp int, nent int) {
+    lpnum = nlp
+    entitynum = nent
+    readConf(*conffile)
+
+    n_events = int(float(nent)*density)
+    Random.Replication = *replication
+    /* a stream per entity, plus stream 0 */
+    if err := Random.Setup(*kernel.Generator, int64(entitynum)+1); err != nil {
+        fmt.Println("GO-WARP,",err)
+        os.Exit(1)
+    }
+    randGen = Random.RandStream(*kernel.Seed, 0)
+
+    initEv = make([]DT.Event, n_events)
+}
+
+
+/*
+ * phold.conf: the first three lines may hold just a value (density, endtime
+ * and FP operations, the old format), the other lines are "key values",
+ * everything after # is a comment
+ */
+func readConf(filename string) {
     var rdErr os.Error
     var line string
-    var str []string = make([]string, 2)
+    var pos int = 0
 
-    file,err := os.Open(conf, os.O_RDONLY,0)
+    file,err := os.Open(filename, os.O_RDONLY,0)
     if err != nil {
         fmt.Println("GO-WARP, error opening the PHOLD configuration file:",err)
         os.Exit(1)
     }
-    rd := bufio.NewReader(file) 
-    
-    for i:=0;rdErr == nil;i++ { 
-        line, rdErr = rd.ReadString('\n') 
-        if len(line) > 0 { 
-            str = strings.Split(line,"#",2)
-            num := strings.Replace(str[0], "\t", "", -1)
-            num = strings.Replace(num, " ", "", -1)
-            if i == 0 {
-                density,_ = strconv.Atof(num)
-            } else if i == 1 {
-                t,_ := strconv.Atoi(num)
-                endtime = DT.Time(t)
-            } else if i == 2 {
-                nFPops,_ = strconv.Atoi(num)
-            }
+    rd := bufio.NewReader(file)
+
+    for n:=1;rdErr == nil;n++ {
+        line, rdErr = rd.ReadString('\n')
+        f := strings.Fields(strings.Split(line,"#",2)[0])
+        if len(f) == 0 {
+            continue
+        }
+        if _,err := strconv.Atof64(f[0]); err == nil && len(f) == 1 && pos < 3 {
+            f = []string{positional[pos], f[0]}
+            pos++
+        }
+        if err := confLine(f); err != nil {
+            fmt.Printf("GO-WARP, error in %s line %d: %s\n",filename,n,err)
+            os.Exit(1)
         }
     }
+    file.Close()
+
     fmt.Println("GO-WARP: read from file:",density,endtime,nFPops)
+    fmt.Println("GO-WARP: PHOLD remote",remote,"lookahead",lookahead,"increment",increment.String(),
+        "hotspot",nhot,hotprob,"unbalance",heavylp,heavyfactor,"fpcost",fpcost,"statesize",statesize)
+}
 
-    lpnum = nlp
-    entitynum = nent
-    n_events = int(float(nent)*density)
-    randGen = Random.RandInit(int64(lpnum+entitynum))
-    idcount = 0
 
-    initEv = make([]DT.Event, n_events)
+var positional = []string{"density", "endtime", "fpops"}
 
-    Sim.Setup(lpnum, endtime, ProcessEvent)
 
-    for i:=0;i<n_events;i++ {
-        e := generateEvent(nil)
-        initEv[i] = *e
+func confLine(f []string) os.Error {
+    if f[0] == "increment" || f[0] == "fpcost" {
+        d,err := Random.ParseDist(f[1:])
+        if err != nil {
+            return err
+        }
+        if f[0] == "increment" {
+            increment = d
+        } else {
+            fpcost = d
+        }
+        return nil
     }
 
-    simterm = false
-    n_term = 0
-}
+    v := make([]float64, len(f)-1)
+    for i:=1;i<len(f);i++ {
+        x,err := strconv.Atof64(f[i])
+        if err != nil {
+            return os.NewError("bad value "+f[i])
+        }
+        v[i-1] = x
+    }
 
+    switch f[0] {
+        case "density", "endtime", "fpops", "remote", "lookahead", "statesize":
+        if len(v) != 1 {
+            return os.NewError(f[0]+" wants one value")
+        }
+    }
 
-func launchLP(index DT.Pid, n_entity int) {
-    var data *Local.LocalData
-    data = Sim.Initialize(index)
+    switch f[0] {
+        case "density":
+        density = float(v[0])
+        case "endtime":
+        endtime = DT.Time(v[0])
+        case "fpops":
+        nFPops = int(v[0])
+        case "remote":
+        remote = v[0]
+        case "lookahead":
+        lookahead = DT.Time(v[0])
+        case "statesize":
+        statesize = int(v[0])
+        case "hotspot":
+        if len(v) != 2 {
+            return os.NewError("hotspot wants the fraction of entities and the probability")
+        }
+        nhot = int(v[0]*float64(entitynum) + 0.5)
+        if nhot < 1 && v[0] > 0 {
+            nhot = 1
+        }
+        hotprob = v[1]
+        case "unbalance":
+        if len(v) != 2 {
+            return os.NewError("unbalance wants the fraction of LPs and the cost factor")
+        }
+        heavylp = int(v[0]*float64(lpnum) + 0.5)
+        heavyfactor = v[1]
+        default:
+        return os.NewError("unknown parameter "+f[0])
+    }
+    return nil
+}
 
-    getEvents(index,data)
 
-    Sim.Simulate(data)
+func initLP(data *Local.LocalData) os.Error {
+    data.LpState = &pholdState{0, make([]byte, statesize)}
 
-    terminate(data)
+    if *resumefile != "" {
+        if err := Checkpoint.Load(*resumefile, data); err != nil {
+            return os.NewError("resuming: "+err.String())
+        }
+    } else if *workload != "" {
+        if err := Workload.Insert(data); err != nil {
+            return os.NewError("loading the workload: "+err.String())
+        }
+    } else {
+        getEvents(data.IndexLP,data)
+    }
+    return nil
 }
 
 
+
+
 /* each event in the system is generated in this function */
-func generateEvent(oldev *DT.Event) *DT.Event {
+func generateEvent(oldev *DT.Event, rng *Random.RNG, id int32) *DT.Event {
     var mitt int
-    var dest int
-    var id int32
     var t DT.Time
 
     if oldev==nil {
-        mitt = int(randGen.RandIntUniform(0,int32(entitynum)))
+        mitt = int(rng.RandIntUniform(0,int32(entitynum-1)))
         t = 0 // basetime
     } else {
         mitt = oldev.Type.To
         t = oldev.Time // basetime
     }
 
-    dest = int(randGen.RandIntUniform(0,int32(entitynum-1)))
-    for ;mitt==dest; {
-        dest = int(randGen.RandIntUniform(0,int32(entitynum-1)))
-    }
-    id = idcount
-    idcount++
-    t += DT.Time(randGen.RandIntExponential())
+    dest := destination(mitt, rng)
+    t += lookahead + DT.Time(increment.Draw(rng))
 
     e := DT.CreateEvent(id, t, DT.Info{mitt,dest,0})
     return e
 }
 
 
-/* each LP gets his events from those that have been generated at start up */
+/*
+ * hot-spot entities are chosen first, then a remote destination (on another
+ * LP) with probability remote or else a local one. With remote < 0 the
+ * destination is uniform over all the other entities
+ */
+func destination(mitt int, rng *Random.RNG) int {
+    var dest int
+
+    if nhot > 0 && rng.RandFloat() < hotprob {
+        return int(rng.RandIntUniform(0,int32(nhot-1)))
+    }
+
+    if remote < 0 {
+        dest = int(rng.RandIntUniform(0,int32(entitynum-1)))
+        for ;mitt==dest; {
+            dest = int(rng.RandIntUniform(0,int32(entitynum-1)))
+        }
+        return dest
+    }
+
+    first,count := Sim.BlockRange(Sim.BlockOwner(mitt, entitynum, lpnum), entitynum, lpnum)
+    if lpnum > 1 && rng.RandFloat() < remote {
+        dest = int(rng.RandIntUniform(0,int32(entitynum-count-1)))
+        if dest >= first {
+            dest += count
+        }
+        return dest
+    }
+    if count == 1 {
+        return mitt
+    }
+    dest = first + int(rng.RandIntUniform(0,int32(count-2)))
+    if dest >= mitt {
+        dest++
+    }
+    return dest
+}
+
+
+/* each LP gets the events of its entities from those that have been generated at start up */
 func getEvents(index DT.Pid, data *Local.LocalData) {
     for i:=0;i<n_events;i++ {
-        if DT.Pid(initEv[i].Type.From/(entitynum/lpnum)) == index {
+        if DT.Pid(Sim.BlockOwner(initEv[i].Type.To, entitynum, lpnum)) == index {
             data.FutureEvents.Insert(&initEv[i])
         }
     }
 }
 
 
-func ProcessEvent(ev *DT.Event, l *Local.LocalData) {
-    newev := generateEvent(ev)
-    lp := e2lp(newev.Type.To,entitynum,lpnum)
-    Sim.NoticeEvent(newev, DT.Pid(lp), l)
-    compute()
+/*
+ * the state of a PHOLD LP is the counter of the generated events, the
+ * identifiers depend only on it so a re-executed event gets the same id.
+ * Payload is statesize bytes of dummy state, copied at every state saving
+ */
+type pholdState struct {
+    NextId int32
+    Payload []byte
 }
 
 
-func e2lp(e, en, lpn int)  int {
-    var lp int
-    m := en % lpn
-    d := en / lpn
-    if m == 0 {
-        lp = e / d
+func (s *pholdState) Copy() DT.LPstate {
+    p := make([]byte, len(s.Payload))
+    copy(p, s.Payload)
+    return &pholdState{s.NextId, p}
+}
+
+
+func (s *pholdState) Marshal() []byte {
+    return []byte(strconv.Itoa(int(s.NextId))+" "+string(s.Payload))
+}
+
+
+func (s *pholdState) Unmarshal(b []byte) os.Error {
+    str := string(b)
+    i := strings.Index(str, " ")
+    if i < 0 {
+        i = len(str)
+        s.Payload = nil
     } else {
-        lp = e / (d+1)
-        if lp >= m {
-            ee := e - m*(d+1)
-            lp = m + ee/d
-        }
+        s.Payload = b[i+1:]
     }
-    return lp
+    n,err := strconv.Atoi(str[0:i])
+    s.NextId = int32(n)
+    return err
 }
 
 
-func compute() float64 {
+func ProcessEvent(ev *DT.Event, l *Local.LocalData) {
+    s := l.LpState.(*pholdState)
+    if len(s.Payload) > 0 {
+        s.Payload[int(ev.Id) % len(s.Payload)]++
+    }
+
+    /* the initial events use the identifiers [0, n_events), or [0, Workload.Len()) */
+    newev := generateEvent(ev, l.Rng, Sim.NewId(n_events+Workload.Len(), &s.NextId, l))
+    lp := Sim.BlockOwner(newev.Type.To, entitynum, lpnum)
+    Sim.NoticeEvent(newev, DT.Pid(lp), l)
+
+    ops := float64(nFPops)
+    if fpcost != nil {
+        ops = fpcost.Draw(l.Rng)
+    }
+    if int(l.IndexLP) < heavylp {
+        ops *= heavyfactor
+    }
+    compute(int(ops))
+}
+
+
+func compute(nops int) float64 {
 	var z,x float64
 	z=2
 	x=0.5
 	
-    for i:=0;i<nFPops/5;i++ {
+    for i:=0;i<nops/5;i++ {
         x = 0.5 * x * (3 - z * x * x)
     }
     return x
@@ -262,35 +548,37 @@ func compute() float64 {
 
 
 func terminate(data *Local.LocalData) {
-    if !simterm {
-        simterm = true
-        endT = time.Nanoseconds()-startT
-    }
-    
-    print.Lock()
-
     fmt.Println("|----------------------------------------------|")
     fmt.Println("LOGICAL PROCESS",data.IndexLP)
     fmt.Println("Number of processed events =",data.N_PROCESSED)
-
-    n_term++
-    print.Unlock()
+    fmt.Println("Number of committed events =",Stats.Lp[data.IndexLP].Committed)
 }
 
 
-func printStats(time int64) {
-    print.Lock()
-
-    fmt.Println("SIMULATION IS COMPLETED: TIME REACHED VALUE",endtime)
-    fmt.Println("Wall Clock Time spent (ms):",float(time)/float(1e6))
-
-    fmt.Println("Number of GVT evaluations:",Shared.N_gvt)
-
-    sum := 0
-    for i:=0;i<lpnum;i++ {
-        sum += Shared.N_rollback[i]
+func printStats(res *Sim.Results) {
+    if res.Stopped {
+        fmt.Println("SIMULATION IS STOPPED: TIME REACHED VALUE",res.Time)
+    } else {
+        fmt.Println("SIMULATION IS COMPLETED: TIME REACHED VALUE",res.Time)
+    }
+    fmt.Println("Wall Clock Time spent (ms):",float(res.WallClock)/float(1e6))
+
+    fmt.Println("Number of GVT evaluations:",res.Gvts)
+    fmt.Println("Total number of rollbacks:",res.Rollbacks)
+    fmt.Println("Total number of committed events:",res.Total.Committed)
+    fmt.Println("Efficiency:",res.Total.Efficiency())
+    if Communication.FaultsEnabled() {
+        d,r,o := Communication.FaultCount()
+        fmt.Println("Fault injection: delayed",d,"reordered",r,"anti-messages first",o)
+    }
+    if Inject.Enabled {
+        fmt.Println("Injected events:",Inject.Injected)
     }
-    fmt.Println("Total number of rollbacks:",sum)
 
-    print.Unlock()
+    if err := Stats.WriteJSON(*statsfile+".json", res.WallClock, res.Gvts); err != nil {
+        fmt.Println("GO-WARP, error writing the statistics:",err)
+    }
+    if err := Stats.WriteCSV(*statsfile+".csv"); err != nil {
+        fmt.Println("GO-WARP, error writing the statistics:",err)
+    }
 }
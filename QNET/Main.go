@@ -0,0 +1,561 @@
+/*
+	Closed queueing network model for GO-WARP
+	http://pads.cs.unibo.it
+  
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+package main
+
+/*
+ * A closed network of FCFS single server stations: a fixed number of jobs
+ * moves among the stations following the routing probabilities. Each
+ * station is an entity, its queue length is the model state. The station
+ * statistics are collected by the commit hook, so they include only the
+ * committed events, and compared with the Mean Value Analysis results
+ */
+
+import( 
+    "../src/DT"
+    "../src/Sim"
+    "../src/Random"
+    "../src/Local"
+    "../src/Stats"
+    "../src/CommitLog"
+    "fmt"
+    "flag"
+    "os"
+    "bufio"
+    "strings"
+    "strconv"
+    "runtime"
+    "math"
+)
+
+const(
+    usage="QNet.out [-conf file] [-seed n] [-rng lcg|mrg32k3a] [-stats prefix] [-commitlog file] [-tol x] [-rollbacks p] [-faults spec] [-check] [-sched spec] [-realtime scale [-rtwindow w]] [-timing] #LPs"
+
+    /* event types, in the Flag field */
+    ARRIVE = 1
+    DEPART = 2
+)
+
+var(
+    lpnum int
+    nstations int
+    njobs int
+    endtime DT.Time		// in clock ticks
+    warmup DT.Time		// in clock ticks
+    scale float64 = 1		// clock ticks per time unit
+    service []*Random.Dist	// service time distributions, in time units
+    routing []*Random.Table	// the values are the destination stations
+    probs [][]float64		// routing probabilities, for the MVA
+
+    /* statistics, each station is written only by its LP */
+    last []DT.Time		// time of the last committed event of each station
+    qarea []float64		// integral of the queue length
+    busy []float64		// time with a job in service
+    completions []int64
+
+    conffile = flag.String("conf", "./QNET/qnet.conf", "network configuration file")
+    kernel = Sim.KernelFlags()	// -seed, -rollbacks, -faults, -check, -sched, -realtime, -rtwindow, -timing, -rng
+    statsfile = flag.String("stats", "logs/qnet", "prefix of the statistics files")
+    commitfile = flag.String("commitlog", "", "canonical log of the committed events, disabled if empty")
+    tol = flag.Float64("tol", 0.05, "maximum relative error with respect to the MVA results")
+)
+
+
+func main() {
+    flag.Parse()
+    if flag.NArg() != 1 {
+        fmt.Println(usage)
+        os.Exit(1)
+    }
+    lpnum,_ = strconv.Atoi(flag.Arg(0))
+
+    readConf(*conffile)
+    if lpnum < 1 || lpnum > nstations {
+        fmt.Println("GO-WARP, the number of LPs must be between 1 and the number of stations")
+        os.Exit(1)
+    }
+    /* a stream per entity, plus stream 0 */
+    if err := Random.Setup(*kernel.Generator, int64(nstations)+1); err != nil {
+        fmt.Println("GO-WARP,",err)
+        os.Exit(1)
+    }
+
+    config := kernel.Config(lpnum, endtime)
+
+    model := &Sim.Model{
+        Process: ProcessEvent,
+        Commit: CommitEvent,
+        Setup: setup,
+        Init: initLP,
+    }
+    res := Sim.Run(config, model)
+    if !res.Started {
+        fmt.Println("GO-WARP, error in the setup:",res.Err)
+        os.Exit(1)
+    }
+    if res.Err != nil {
+        /* the scheduler failures (step limit, deadlock) end the run with exit status 3 */
+        fmt.Println("GO-WARP, deterministic mode:",res.Err)
+        os.Exit(3)
+    }
+    wall := res.WallClock
+    checked := res.CheckReport()
+
+    /* the last interval of each station, up to the end time */
+    for i:=0;i<nstations;i++ {
+        lp := Sim.BlockOwner(i, nstations, lpnum)
+        first,_ := Sim.BlockRange(lp, nstations, lpnum)
+        account(i, res.Datas[lp].LpState.(*qnetState).N[i-first], endtime)
+    }
+
+    if err := CommitLog.Close(); err != nil {
+        fmt.Println("GO-WARP, error writing the committed events log:",err)
+    }
+    fmt.Println("SIMULATION IS COMPLETED: TIME REACHED VALUE",endtime)
+    fmt.Println("Wall Clock Time spent (ms):",float64(wall)/1e6)
+    tot := res.Total
+    fmt.Println("Total number of committed events:",tot.Committed)
+    fmt.Println("Efficiency:",tot.Efficiency())
+    if err := Stats.WriteJSON(*statsfile+".json", wall, res.Gvts); err != nil {
+        fmt.Println("GO-WARP, error writing the statistics:",err)
+    }
+    if err := Stats.WriteCSV(*statsfile+".csv"); err != nil {
+        fmt.Println("GO-WARP, error writing the statistics:",err)
+    }
+
+    if !report(*statsfile+".stations.csv") || !checked {
+        os.Exit(1)
+    }
+}
+
+
+/* the kernel is set up, the LPs are not created yet */
+func setup() os.Error {
+    if *commitfile != "" {
+        if err := CommitLog.Setup(lpnum, *commitfile); err != nil {
+            return os.NewError("creating the committed events log: "+err.String())
+        }
+    }
+
+    fmt.Println("GO-WARP: the simulator will use",runtime.GOMAXPROCS(-1),"COREs")
+    fmt.Println("GO-WARP: the simulation will use",lpnum,"LPs")
+    return nil
+}
+
+
+/*
+ * qnet.conf holds "key values" lines, everything after # is a comment:
+ * stations n, jobs n, endtime t, warmup t, scale ticks, service station
+ * distribution, route from to probability
+ */
+func readConf(filename string) {
+    var rdErr os.Error
+    var line string
+
+    file,err := os.Open(filename, os.O_RDONLY,0)
+    if err != nil {
+        fmt.Println("GO-WARP, error opening the network configuration file:",err)
+        os.Exit(1)
+    }
+    rd := bufio.NewReader(file)
+
+    var end, warm float64
+    for n:=1;rdErr == nil;n++ {
+        line, rdErr = rd.ReadString('\n')
+        f := strings.Fields(strings.Split(line,"#",2)[0])
+        if len(f) == 0 {
+            continue
+        }
+        if err := confLine(f, &end, &warm); err != nil {
+            fmt.Printf("GO-WARP, error in %s line %d: %s\n",filename,n,err)
+            os.Exit(1)
+        }
+    }
+    file.Close()
+
+    endtime = DT.Time(end*scale)
+    warmup = DT.Time(warm*scale)
+    if nstations == 0 || njobs == 0 || warmup >= endtime {
+        fmt.Println("GO-WARP, stations, jobs and endtime > warmup must be set in",filename)
+        os.Exit(1)
+    }
+    routing = make([]*Random.Table, nstations)
+    for i:=0;i<nstations;i++ {
+        if service[i] == nil {
+            fmt.Println("GO-WARP, no service time distribution for station",i)
+            os.Exit(1)
+        }
+        values := make([]float64, nstations)
+        sum := 0.0
+        for j:=0;j<nstations;j++ {
+            values[j] = float64(j)
+            sum += probs[i][j]
+        }
+        if math.Fabs(sum-1) > 1e-6 {
+            fmt.Println("GO-WARP, the routing probabilities of station",i,"sum to",sum)
+            os.Exit(1)
+        }
+        var err os.Error
+        routing[i],err = Random.NewTable(values, probs[i])
+        if err != nil {
+            fmt.Println("GO-WARP, the routing probabilities of station",i,":",err)
+            os.Exit(1)
+        }
+    }
+
+    last = make([]DT.Time, nstations)
+    qarea = make([]float64, nstations)
+    busy = make([]float64, nstations)
+    completions = make([]int64, nstations)
+
+    fmt.Println("GO-WARP: read from file:",nstations,"stations,",njobs,"jobs, endtime",end,"warmup",warm,"scale",scale)
+}
+
+
+func confLine(f []string, end *float64, warm *float64) os.Error {
+    var err os.Error
+
+    switch f[0] {
+        case "stations":
+        if len(f) != 2 || nstations != 0 {
+            return os.NewError("stations wants one value, before service and route")
+        }
+        nstations,err = strconv.Atoi(f[1])
+        service = make([]*Random.Dist, nstations)
+        probs = make([][]float64, nstations)
+        for i:=0;i<nstations;i++ {
+            probs[i] = make([]float64, nstations)
+        }
+        case "jobs":
+        njobs,err = strconv.Atoi(f[1])
+        case "endtime":
+        *end,err = strconv.Atof64(f[1])
+        case "warmup":
+        *warm,err = strconv.Atof64(f[1])
+        case "scale":
+        scale,err = strconv.Atof64(f[1])
+        case "service":
+        if len(f) < 3 {
+            return os.NewError("service wants a station and a distribution")
+        }
+        i,err := station(f[1])
+        if err != nil {
+            return err
+        }
+        service[i],err = Random.ParseDist(f[2:])
+        return err
+        case "route":
+        if len(f) != 4 {
+            return os.NewError("route wants two stations and a probability")
+        }
+        i,err := station(f[1])
+        if err != nil {
+            return err
+        }
+        j,err := station(f[2])
+        if err != nil {
+            return err
+        }
+        probs[i][j],err = strconv.Atof64(f[3])
+        return err
+        default:
+        return os.NewError("unknown parameter "+f[0])
+    }
+    return err
+}
+
+
+func station(s string) (int, os.Error) {
+    i,err := strconv.Atoi(s)
+    if err != nil || i < 0 || i >= nstations {
+        return 0, os.NewError("bad station "+s)
+    }
+    return i, nil
+}
+
+
+/* the jobs start at the stations in round robin order */
+func initLP(data *Local.LocalData) os.Error {
+    first,count := Sim.BlockRange(int(data.IndexLP), nstations, lpnum)
+    data.LpState = &qnetState{0, make([]int32, count)}
+
+    for j:=0;j<njobs;j++ {
+        i := j % nstations
+        if i >= first && i < first+count {
+            data.NewEvent(DT.CreateEvent(int32(j), 0, DT.Info{i,i,ARRIVE}))
+        }
+    }
+    return nil
+}
+
+
+/* the queue lengths of the stations of the LP, jobs in service included */
+type qnetState struct {
+    NextId int32
+    N []int32
+}
+
+
+func (s *qnetState) Copy() DT.LPstate {
+    n := make([]int32, len(s.N))
+    copy(n, s.N)
+    return &qnetState{s.NextId, n}
+}
+
+
+func (s *qnetState) Marshal() []byte {
+    str := strconv.Itoa(int(s.NextId))
+    for i:=0;i<len(s.N);i++ {
+        str += " "+strconv.Itoa(int(s.N[i]))
+    }
+    return []byte(str)
+}
+
+
+func (s *qnetState) Unmarshal(b []byte) os.Error {
+    f := strings.Fields(string(b))
+    if len(f) != len(s.N)+1 {
+        return os.NewError("wrong number of stations in the state")
+    }
+    v := make([]int, len(f))
+    for i:=0;i<len(f);i++ {
+        n,err := strconv.Atoi(f[i])
+        if err != nil {
+            return err
+        }
+        v[i] = n
+    }
+    s.NextId = int32(v[0])
+    for i:=0;i<len(s.N);i++ {
+        s.N[i] = int32(v[i+1])
+    }
+    return nil
+}
+
+
+func ProcessEvent(ev *DT.Event, l *Local.LocalData) {
+    s := l.LpState.(*qnetState)
+    i := ev.Type.To
+    first,_ := Sim.BlockRange(int(l.IndexLP), nstations, lpnum)
+    k := i-first
+
+    switch ev.Type.Flag {
+        case ARRIVE:
+        s.N[k]++
+        if s.N[k] == 1 {
+            schedule(ev.Time, i, l)
+        }
+
+        case DEPART:
+        s.N[k]--
+        if s.N[k] > 0 {
+            schedule(ev.Time, i, l)
+        }
+        j := int(routing[i].Values[l.Rng.Discrete(routing[i])])
+        send(DT.CreateEvent(Sim.NewId(njobs, &s.NextId, l), ev.Time, DT.Info{i,j,ARRIVE}), l)
+    }
+}
+
+
+/* the job at the head of the queue of station i starts its service at t */
+func schedule(t DT.Time, i int, l *Local.LocalData) {
+    d := DT.Time(service[i].Draw(l.Rng)*scale + 0.5)
+    if d < 1 {
+        d = 1
+    }
+    s := l.LpState.(*qnetState)
+    send(DT.CreateEvent(Sim.NewId(njobs, &s.NextId, l), t+d, DT.Info{i,i,DEPART}), l)
+}
+
+
+func send(ev *DT.Event, l *Local.LocalData) {
+    Sim.NoticeEvent(ev, DT.Pid(Sim.BlockOwner(ev.Type.To, nstations, lpnum)), l)
+}
+
+
+/* commit hook: st is the state before ev, so it holds the queue length since the previous event */
+func CommitEvent(ev *DT.Event, st DT.LPstate, l *Local.LocalData) {
+    i := ev.Type.To
+    first,_ := Sim.BlockRange(int(l.IndexLP), nstations, lpnum)
+
+    account(i, st.(*qnetState).N[i-first], ev.Time)
+    if ev.Type.Flag == DEPART && ev.Time >= warmup {
+        completions[i]++
+    }
+}
+
+
+/* the queue length of station i has been n since its last event, up to t */
+func account(i int, n int32, t DT.Time) {
+    from := last[i]
+    if from < warmup {
+        from = warmup
+    }
+    if t > from {
+        qarea[i] += float64(n)*float64(t-from)
+        if n > 0 {
+            busy[i] += float64(t-from)
+        }
+    }
+    last[i] = t
+}
+
+
+/*
+ * visit ratios of the stations, v = vP with v[0] = 1, solved by Gaussian
+ * elimination: the equation of station 0 is replaced by v[0] = 1
+ */
+func visits() []float64 {
+    n := nstations
+    a := make([][]float64, n)
+    for j:=0;j<n;j++ {
+        a[j] = make([]float64, n+1)
+        for i:=0;i<n;i++ {
+            a[j][i] = probs[i][j]
+        }
+        a[j][j] -= 1
+    }
+    for i:=0;i<=n;i++ {
+        a[0][i] = 0
+    }
+    a[0][0] = 1
+    a[0][n] = 1
+
+    for c:=0;c<n;c++ {
+        p := c
+        for r:=c+1;r<n;r++ {
+            if math.Fabs(a[r][c]) > math.Fabs(a[p][c]) {
+                p = r
+            }
+        }
+        a[c],a[p] = a[p],a[c]
+        for r:=0;r<n;r++ {
+            if r != c && a[c][c] != 0 {
+                k := a[r][c]/a[c][c]
+                for i:=c;i<=n;i++ {
+                    a[r][i] -= k*a[c][i]
+                }
+            }
+        }
+    }
+
+    v := make([]float64, n)
+    for i:=0;i<n;i++ {
+        v[i] = a[i][n]/a[i][i]
+    }
+    return v
+}
+
+
+/*
+ * exact Mean Value Analysis of the network with njobs jobs, it returns per
+ * station utilization, mean queue length, throughput and response time per visit
+ */
+func mva() (u, q, x, r []float64) {
+    n := nstations
+    v := visits()
+    d := make([]float64, n)
+    u = make([]float64, n)
+    q = make([]float64, n)
+    x = make([]float64, n)
+    r = make([]float64, n)
+
+    for i:=0;i<n;i++ {
+        d[i] = v[i]*service[i].Mean()
+    }
+    var tput float64
+    for k:=1;k<=njobs;k++ {
+        sum := 0.0
+        for i:=0;i<n;i++ {
+            r[i] = d[i]*(1+q[i])
+            sum += r[i]
+        }
+        tput = float64(k)/sum
+        for i:=0;i<n;i++ {
+            q[i] = tput*r[i]
+        }
+    }
+    for i:=0;i<n;i++ {
+        u[i] = tput*d[i]
+        x[i] = tput*v[i]
+        r[i] = q[i]/x[i]
+    }
+    return
+}
+
+
+func relerr(sim float64, exact float64) float64 {
+    if exact == 0 {
+        return math.Fabs(sim)
+    }
+    return math.Fabs(sim-exact)/exact
+}
+
+
+/*
+ * prints and writes the station statistics next to the MVA results, it
+ * returns false if an error is above the tolerance. MVA is exact only for
+ * exponential service times, otherwise the errors are just reported
+ */
+func report(filename string) bool {
+    var ok bool = true
+
+    exact := true
+    for i:=0;i<nstations;i++ {
+        if service[i].Kind != "exponential" {
+            exact = false
+        }
+    }
+    mu,mq,mx,mr := mva()
+    T := float64(endtime-warmup)
+
+    file,err := os.Open(filename, os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0644)
+    if err != nil {
+        fmt.Println("GO-WARP, error writing the station statistics:",err)
+        return false
+    }
+    w := bufio.NewWriter(file)
+    fmt.Fprintln(w, "station,utilization,mva_utilization,queue,mva_queue,throughput,mva_throughput,response,mva_response")
+
+    fmt.Printf("\n%7s %10s %10s %10s %10s %10s %10s %10s %10s\n", "station", "U", "U(MVA)", "Q", "Q(MVA)",
+        "X", "X(MVA)", "R", "R(MVA)")
+    for i:=0;i<nstations;i++ {
+        u := busy[i]/T
+        q := qarea[i]/T
+        x := float64(completions[i])/T*scale
+        r := 0.0
+        if x > 0 {
+            r = q/x
+        }
+        fmt.Printf("%7d %10.4f %10.4f %10.4f %10.4f %10.4f %10.4f %10.4f %10.4f\n", i, u, mu[i], q, mq[i], x, mx[i], r, mr[i])
+        fmt.Fprintf(w, "%d,%g,%g,%g,%g,%g,%g,%g,%g\n", i, u, mu[i], q, mq[i], x, mx[i], r, mr[i])
+
+        if relerr(u,mu[i]) > *tol || relerr(q,mq[i]) > *tol || relerr(x,mx[i]) > *tol || relerr(r,mr[i]) > *tol {
+            if exact {
+                ok = false
+            }
+            fmt.Println("        station",i,"differs from MVA by more than",*tol)
+        }
+    }
+    w.Flush()
+    file.Close()
+
+    if !exact {
+        fmt.Println("MVA CHECK: SKIPPED, THE SERVICE TIMES ARE NOT ALL EXPONENTIAL")
+    } else if ok {
+        fmt.Println("MVA CHECK: OK")
+    } else {
+        fmt.Println("MVA CHECK: FAILED")
+    }
+    return ok || !exact
+}
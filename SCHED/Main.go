@@ -0,0 +1,212 @@
+/*
+	GO-WARP: a Time Warp simulator written in Go
+	http://pads.cs.unibo.it
+
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+
+package main
+
+/*
+ * Looks for a failing interleaving of the LPs with the deterministic mode
+ * of the models: the model is run with one scheduler seed after the other
+ * until a run fails (exit status not 0), then its schedule is shrunk by
+ * removing and zeroing decisions as long as the run keeps failing with the
+ * same status. The minimal schedule can be replayed with -sched replay=file
+ */
+
+import(
+    "../src/Sched"
+    "fmt"
+    "flag"
+    "io"
+    "os"
+    "exec"
+    "strings"
+    "strconv"
+)
+
+const usage = "Sched.out [-model file] [-seeds first:last] [-steps n] [-runs n] [-dir dir] [-q] -- model arguments"
+
+var(
+    model = flag.String("model", "./builds/Main.out", "model executable")
+    seeds = flag.String("seeds", "1:100", "range of the scheduler seeds")
+    steps = flag.Int64("steps", 0, "step limit of each run, 0 for no limit")
+    maxRuns = flag.Int("runs", 500, "maximum number of runs while shrinking")
+    dir = flag.String("dir", "./logs/", "directory of the schedules and of the output of the runs")
+    quiet = flag.Bool("q", false, "prints only the result")
+
+    runs int = 0
+)
+
+
+func main() {
+    flag.Parse()
+    if flag.NArg() == 0 {
+        fmt.Println(usage)
+        os.Exit(2)
+    }
+    f := strings.Split(*seeds, ":", 2)
+    first,err1 := strconv.Atoi64(f[0])
+    last := first
+    var err2 os.Error
+    if len(f) == 2 {
+        last,err2 = strconv.Atoi64(f[1])
+    }
+    if err1 != nil || err2 != nil || last < first {
+        fmt.Println("SCHED, bad seed range",*seeds)
+        os.Exit(2)
+    }
+    rec := *dir+"sched.rec"
+
+    /* exploration */
+    var status int = 0
+    var seed int64
+    for seed=first; seed<=last && status==0; seed++ {
+        if !*quiet {
+            fmt.Println("SCHED: seed",seed)
+        }
+        status = run(fmt.Sprintf("seed=%d,record=%s", seed, rec))
+    }
+    if status == 0 {
+        fmt.Println("SCHED: no failing schedule with seeds",*seeds)
+        return
+    }
+    seed--
+
+    d,err := Sched.Load(rec)
+    if err != nil {
+        fmt.Println("SCHED, error reading the schedule:",err)
+        os.Exit(2)
+    }
+    fmt.Println("SCHED: seed",seed,"fails with exit status",status,"after",len(d),"decisions, replay it with -sched seed="+strconv.Itoa64(seed))
+
+    /* shrinking, the decisions after the end of the file are 0 */
+    d = shrink(trim(d), status)
+    min := *dir+"sched.min"
+    if err := Sched.Save(min, d); err != nil {
+        fmt.Println("SCHED, error writing the schedule:",err)
+        os.Exit(2)
+    }
+    run("replay="+min)		// leaves its output in sched.out
+
+    nz := 0
+    for i:=0;i<len(d);i++ {
+        if d[i] != 0 {
+            nz++
+        }
+    }
+    fmt.Println("SCHED: shrunk to",len(d),"decisions,",nz,"not default, in",runs,"runs")
+    fmt.Println("SCHED: replay it with -sched replay="+min+", the output is in "+*dir+"sched.out")
+    os.Exit(1)
+}
+
+
+/*
+ * removes chunks of decisions of decreasing size, then sets each decision
+ * to the default, as long as the run fails with the same status
+ */
+func shrink(d []int, status int) []int {
+    try := *dir+"sched.try"
+
+    fails := func(c []int) bool {
+        if runs >= *maxRuns {
+            return false
+        }
+        if err := Sched.Save(try, c); err != nil {
+            fmt.Println("SCHED, error writing the schedule:",err)
+            os.Exit(2)
+        }
+        return run("replay="+try) == status
+    }
+
+    for chunk:=len(d)/2; chunk>=1; chunk/=2 {
+        for i:=0; i<len(d); {
+            end := i+chunk
+            if end > len(d) {
+                end = len(d)
+            }
+            c := make([]int, len(d)-(end-i))
+            copy(c, d[0:i])
+            copy(c[i:], d[end:])
+            if fails(c) {
+                d = c
+                if !*quiet {
+                    fmt.Println("SCHED:",len(d),"decisions")
+                }
+            } else {
+                i = end
+            }
+        }
+    }
+
+    for i:=0;i<len(d);i++ {
+        if d[i] == 0 {
+            continue
+        }
+        c := make([]int, len(d))
+        copy(c, d)
+        c[i] = 0
+        if fails(c) {
+            d = c
+        }
+    }
+    return trim(d)
+}
+
+
+/* the trailing defaults are the same as the end of the schedule */
+func trim(d []int) []int {
+    n := len(d)
+    for n > 0 && d[n-1] == 0 {
+        n--
+    }
+    return d[0:n]
+}
+
+
+/* runs the model with the given scheduler parameters, returns the exit status */
+func run(spec string) int {
+    runs++
+    if *steps > 0 {
+        spec += ",steps="+strconv.Itoa64(*steps)
+    }
+    args := flag.Args()
+    argv := make([]string, len(args)+3)
+    argv[0] = *model
+    argv[1] = "-sched"
+    argv[2] = spec
+    for i:=0;i<len(args);i++ {
+        argv[i+3] = args[i]
+    }
+
+    out,err := os.Open(*dir+"sched.out", os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0644)
+    if err != nil {
+        fmt.Println("SCHED, error creating the output file:",err)
+        os.Exit(2)
+    }
+    defer out.Close()
+
+    cmd,err := exec.Run(*model, argv, os.Environ(), "", exec.DevNull, exec.Pipe, exec.MergeWithStdout)
+    if err != nil {
+        fmt.Println("SCHED, error running the model:",err)
+        os.Exit(2)
+    }
+    io.Copy(out, cmd.Stdout)
+    w,err := cmd.Wait(0)
+    if err != nil {
+        fmt.Println("SCHED, error running the model:",err)
+        os.Exit(2)
+    }
+    if !w.Exited() {
+        return -1
+    }
+    return w.ExitStatus()
+}
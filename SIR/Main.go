@@ -0,0 +1,546 @@
+/*
+	SIR/SEIR epidemic model for GO-WARP
+	http://pads.cs.unibo.it
+  
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+package main
+
+/*
+ * An epidemic spreading over a small world contact network (Watts-Strogatz:
+ * a ring lattice with rewired edges). Each person is an entity and each LP
+ * simulates many of them, the partitioning of the persons among the LPs
+ * decides how many infections cross the LP boundaries. A person becomes
+ * exposed when infected, infectious at the onset and removed at recovery
+ * (without a latent period the model is SIR). The daily numbers of
+ * susceptible, exposed, infectious and removed persons are computed from
+ * the committed events by the commit hook
+ */
+
+import( 
+    "../src/DT"
+    "../src/Sim"
+    "../src/Random"
+    "../src/Local"
+    "../src/Stats"
+    "../src/CommitLog"
+    "fmt"
+    "flag"
+    "os"
+    "bufio"
+    "strings"
+    "strconv"
+    "runtime"
+)
+
+const(
+    usage="SIR.out [-conf file] [-seed n] [-rng lcg|mrg32k3a] [-stats prefix] [-commitlog file] [-rollbacks p] [-faults spec] [-check] [-sched spec] [-realtime scale [-rtwindow w]] [-timing] #LPs"
+
+    /* event types, in the Flag field */
+    INFECT = 1		// an infectious contact reaches the person
+    ONSET = 2		// the person becomes infectious
+    RECOVER = 3		// the person is removed
+
+    /* status of a person */
+    SUSCEPTIBLE = 0
+    EXPOSED = 1
+    INFECTIOUS = 2
+    REMOVED = 3
+)
+
+var(
+    lpnum int
+    npersons int
+    degree int = 8		// neighbors in the ring lattice, even
+    rewire float64 = 0.1	// probability of rewiring an edge
+    partition string = "block"	// block, roundrobin or random
+    ninitial int = 10		// persons infected at time 0
+    days int
+    scale float64 = 100		// clock ticks per day
+    transmission float64	// infection rate of a contact, per day
+    latent *Random.Dist = nil	// latent period in days, nil for SIR
+    infectious *Random.Dist	// infectious period in days
+
+    /* contact network, the neighbors of i are adj[adjStart[i]:adjStart[i+1]] */
+    adjStart []int
+    adj []int
+
+    owner []int			// LP of each person
+    local []int			// index of each person among the ones of its LP
+    members [][]int		// persons of each LP
+
+    /* transitions per LP and day, written by the commit hook of each LP */
+    infections [][]int64
+    onsets [][]int64
+    recoveries [][]int64
+
+    conffile = flag.String("conf", "./SIR/sir.conf", "epidemic configuration file")
+    kernel = Sim.KernelFlags()	// -seed, -rollbacks, -faults, -check, -sched, -realtime, -rtwindow, -timing, -rng
+    statsfile = flag.String("stats", "logs/sir", "prefix of the statistics files")
+    commitfile = flag.String("commitlog", "", "canonical log of the committed events, disabled if empty")
+)
+
+
+func main() {
+    flag.Parse()
+    if flag.NArg() != 1 {
+        fmt.Println(usage)
+        os.Exit(1)
+    }
+    lpnum,_ = strconv.Atoi(flag.Arg(0))
+
+    readConf(*conffile)
+    if lpnum < 1 || lpnum > npersons {
+        fmt.Println("GO-WARP, the number of LPs must be between 1 and the number of persons")
+        os.Exit(1)
+    }
+    /* a stream per entity, plus stream 0 */
+    if err := Random.Setup(*kernel.Generator, int64(npersons)+1); err != nil {
+        fmt.Println("GO-WARP,",err)
+        os.Exit(1)
+    }
+
+    /* the network, the partitioning and the initial infections are drawn from stream 0 */
+    rng := Random.RandStream(*kernel.Seed, 0)
+    buildNetwork(rng)
+    buildPartition(rng)
+
+    config := kernel.Config(lpnum, DT.Time(float64(days)*scale))
+
+    initial := make([]int, ninitial)
+    for j:=0;j<ninitial;j++ {
+        initial[j] = int(rng.RandIntUniform(0, int32(npersons-1)))
+    }
+    model := &Sim.Model{
+        Process: ProcessEvent,
+        Commit: CommitEvent,
+        Setup: setup,
+        Init: func(data *Local.LocalData) os.Error {
+            data.LpState = &sirState{0, make([]int8, len(members[data.IndexLP]))}
+            for j,p := range initial {
+                if owner[p] == int(data.IndexLP) {
+                    data.NewEvent(DT.CreateEvent(int32(j), 0, DT.Info{p,p,INFECT}))
+                }
+            }
+            return nil
+        },
+    }
+    res := Sim.Run(config, model)
+    if !res.Started {
+        fmt.Println("GO-WARP, error in the setup:",res.Err)
+        os.Exit(1)
+    }
+    if res.Err != nil {
+        /* the scheduler failures (step limit, deadlock) end the run with exit status 3 */
+        fmt.Println("GO-WARP, deterministic mode:",res.Err)
+        os.Exit(3)
+    }
+    wall := res.WallClock
+    checked := res.CheckReport()
+
+    if err := CommitLog.Close(); err != nil {
+        fmt.Println("GO-WARP, error writing the committed events log:",err)
+    }
+    fmt.Println("SIMULATION IS COMPLETED: TIME REACHED VALUE",res.Time)
+    fmt.Println("Wall Clock Time spent (ms):",float64(wall)/1e6)
+    tot := res.Total
+    fmt.Println("Total number of committed events:",tot.Committed)
+    fmt.Println("Total number of rollbacks:",tot.Rollbacks)
+    fmt.Println("Efficiency:",tot.Efficiency())
+    fmt.Println("Remote contacts:",remoteEdges(),"of",len(adj)/2)
+    if err := Stats.WriteJSON(*statsfile+".json", wall, res.Gvts); err != nil {
+        fmt.Println("GO-WARP, error writing the statistics:",err)
+    }
+    if err := Stats.WriteCSV(*statsfile+".csv"); err != nil {
+        fmt.Println("GO-WARP, error writing the statistics:",err)
+    }
+    report(*statsfile+".daily.csv")
+    if !checked {
+        os.Exit(1)
+    }
+}
+
+
+/* the kernel is set up, the LPs are not created yet */
+func setup() os.Error {
+    if *commitfile != "" {
+        if err := CommitLog.Setup(lpnum, *commitfile); err != nil {
+            return os.NewError("creating the committed events log: "+err.String())
+        }
+    }
+
+    fmt.Println("GO-WARP: the simulator will use",runtime.GOMAXPROCS(-1),"COREs")
+    fmt.Println("GO-WARP: the simulation will use",lpnum,"LPs")
+    return nil
+}
+
+
+/*
+ * sir.conf holds "key values" lines, everything after # is a comment:
+ * persons n, degree k, rewire p, partition block|roundrobin|random,
+ * initial n, days n, scale ticks, transmission rate, latent dist|none,
+ * infectious dist
+ */
+func readConf(filename string) {
+    var rdErr os.Error
+    var line string
+
+    file,err := os.Open(filename, os.O_RDONLY,0)
+    if err != nil {
+        fmt.Println("GO-WARP, error opening the epidemic configuration file:",err)
+        os.Exit(1)
+    }
+    rd := bufio.NewReader(file)
+
+    for n:=1;rdErr == nil;n++ {
+        line, rdErr = rd.ReadString('\n')
+        f := strings.Fields(strings.Split(line,"#",2)[0])
+        if len(f) == 0 {
+            continue
+        }
+        if len(f) < 2 {
+            fmt.Printf("GO-WARP, error in %s line %d: %s wants a value\n",filename,n,f[0])
+            os.Exit(1)
+        }
+
+        var err os.Error
+        switch f[0] {
+            case "persons":
+            npersons,err = strconv.Atoi(f[1])
+            case "degree":
+            degree,err = strconv.Atoi(f[1])
+            case "rewire":
+            rewire,err = strconv.Atof64(f[1])
+            case "partition":
+            partition = f[1]
+            if partition != "block" && partition != "roundrobin" && partition != "random" {
+                err = os.NewError("unknown partitioning "+partition)
+            }
+            case "initial":
+            ninitial,err = strconv.Atoi(f[1])
+            case "days":
+            days,err = strconv.Atoi(f[1])
+            case "scale":
+            scale,err = strconv.Atof64(f[1])
+            case "transmission":
+            transmission,err = strconv.Atof64(f[1])
+            case "latent":
+            if f[1] == "none" {
+                latent = nil
+            } else {
+                latent,err = Random.ParseDist(f[1:])
+            }
+            case "infectious":
+            infectious,err = Random.ParseDist(f[1:])
+            default:
+            err = os.NewError("unknown parameter "+f[0])
+        }
+        if err != nil {
+            fmt.Printf("GO-WARP, error in %s line %d: %s\n",filename,n,err)
+            os.Exit(1)
+        }
+    }
+    file.Close()
+
+    if npersons <= degree || degree < 2 || degree % 2 != 0 || days == 0 || transmission <= 0 || infectious == nil {
+        fmt.Println("GO-WARP, persons > degree, an even degree, days, transmission and infectious must be set in",filename)
+        os.Exit(1)
+    }
+    fmt.Println("GO-WARP: read from file:",npersons,"persons, degree",degree,"rewire",rewire,"partition",partition,
+        "initial",ninitial,"days",days,"scale",scale)
+    fmt.Println("GO-WARP: transmission",transmission,"latent",latent,"infectious",infectious)
+}
+
+
+/*
+ * Watts-Strogatz network: every person is linked to the degree/2 following
+ * ones on the ring, then each link is moved to a random person with
+ * probability rewire (self loops and duplicates are avoided)
+ */
+func buildNetwork(rng *Random.RNG) {
+    nedges := npersons*degree/2
+    from := make([]int, nedges)
+    to := make([]int, nedges)
+    edges := make(map[int64]bool)
+
+    key := func(a int, b int) int64 {
+        if a > b {
+            a,b = b,a
+        }
+        return int64(a)*int64(npersons) + int64(b)
+    }
+
+    e := 0
+    for i:=0;i<npersons;i++ {
+        for j:=1;j<=degree/2;j++ {
+            from[e] = i
+            to[e] = (i+j) % npersons
+            edges[key(from[e],to[e])] = true
+            e++
+        }
+    }
+    for e=0;e<nedges;e++ {
+        if rng.RandFloat() >= rewire {
+            continue
+        }
+        i := from[e]
+        j := int(rng.RandIntUniform(0, int32(npersons-1)))
+        if j == i || edges[key(i,j)] {
+            continue
+        }
+        edges[key(i,to[e])] = false, false
+        to[e] = j
+        edges[key(i,j)] = true
+    }
+
+    /* adjacency lists, each link in both directions */
+    adjStart = make([]int, npersons+1)
+    for e=0;e<nedges;e++ {
+        adjStart[from[e]+1]++
+        adjStart[to[e]+1]++
+    }
+    for i:=0;i<npersons;i++ {
+        adjStart[i+1] += adjStart[i]
+    }
+    adj = make([]int, 2*nedges)
+    next := make([]int, npersons)
+    copy(next, adjStart[0:npersons])
+    for e=0;e<nedges;e++ {
+        adj[next[from[e]]] = to[e]
+        next[from[e]]++
+        adj[next[to[e]]] = from[e]
+        next[to[e]]++
+    }
+}
+
+
+/*
+ * block: consecutive persons on the ring (the lattice links are local),
+ * roundrobin: person i to LP i%lpnum, random: a random permutation in blocks
+ */
+func buildPartition(rng *Random.RNG) {
+    perm := make([]int, npersons)
+    for i:=0;i<npersons;i++ {
+        perm[i] = i
+    }
+    if partition == "random" {
+        for i:=npersons-1;i>0;i-- {
+            j := int(rng.RandIntUniform(0, int32(i)))
+            perm[i],perm[j] = perm[j],perm[i]
+        }
+    }
+
+    owner = make([]int, npersons)
+    local = make([]int, npersons)
+    members = make([][]int, lpnum)
+    count := make([]int, lpnum)
+    for k:=0;k<npersons;k++ {
+        lp := k*lpnum/npersons
+        if partition == "roundrobin" {
+            lp = k % lpnum
+        }
+        owner[perm[k]] = lp
+        local[perm[k]] = count[lp]
+        count[lp]++
+    }
+    for lp:=0;lp<lpnum;lp++ {
+        members[lp] = make([]int, count[lp])
+    }
+    for i:=0;i<npersons;i++ {
+        members[owner[i]][local[i]] = i
+    }
+
+    ndays := days+1
+    infections = make([][]int64, lpnum)
+    onsets = make([][]int64, lpnum)
+    recoveries = make([][]int64, lpnum)
+    for lp:=0;lp<lpnum;lp++ {
+        infections[lp] = make([]int64, ndays)
+        onsets[lp] = make([]int64, ndays)
+        recoveries[lp] = make([]int64, ndays)
+    }
+}
+
+
+/* links between persons of different LPs */
+func remoteEdges() int {
+    n := 0
+    for i:=0;i<npersons;i++ {
+        for k:=adjStart[i];k<adjStart[i+1];k++ {
+            if adj[k] > i && owner[adj[k]] != owner[i] {
+                n++
+            }
+        }
+    }
+    return n
+}
+
+
+/* the status of the persons of the LP */
+type sirState struct {
+    NextId int32
+    Status []int8
+}
+
+
+func (s *sirState) Copy() DT.LPstate {
+    st := make([]int8, len(s.Status))
+    copy(st, s.Status)
+    return &sirState{s.NextId, st}
+}
+
+
+/* the id counter followed by one digit per person */
+func (s *sirState) Marshal() []byte {
+    b := make([]byte, len(s.Status))
+    for i:=0;i<len(s.Status);i++ {
+        b[i] = '0'+byte(s.Status[i])
+    }
+    return []byte(strconv.Itoa(int(s.NextId))+" "+string(b))
+}
+
+
+func (s *sirState) Unmarshal(b []byte) os.Error {
+    f := strings.Fields(string(b))
+    if len(f) != 2 || len(f[1]) != len(s.Status) {
+        return os.NewError("wrong number of persons in the state")
+    }
+    n,err := strconv.Atoi(f[0])
+    if err != nil {
+        return err
+    }
+    s.NextId = int32(n)
+    for i:=0;i<len(s.Status);i++ {
+        s.Status[i] = int8(f[1][i]-'0')
+    }
+    return nil
+}
+
+
+func ProcessEvent(ev *DT.Event, l *Local.LocalData) {
+    s := l.LpState.(*sirState)
+    p := ev.Type.To
+    k := local[p]
+
+    switch ev.Type.Flag {
+        case INFECT:
+        if s.Status[k] != SUSCEPTIBLE {
+            return
+        }
+        if latent != nil {
+            s.Status[k] = EXPOSED
+            send(ev.Time+ticks(latent, l.Rng), p, p, ONSET, l)
+        } else {
+            onset(ev.Time, p, l)
+        }
+
+        case ONSET:
+        onset(ev.Time, p, l)
+
+        case RECOVER:
+        s.Status[k] = REMOVED
+    }
+}
+
+
+/*
+ * person p becomes infectious at t: the recovery is scheduled and each
+ * neighbor is infected if the contact happens before it
+ */
+func onset(t DT.Time, p int, l *Local.LocalData) {
+    s := l.LpState.(*sirState)
+    s.Status[local[p]] = INFECTIOUS
+
+    d := infectious.Draw(l.Rng)
+    send(t+DT.Time(d*scale+0.5), p, p, RECOVER, l)
+    for k:=adjStart[p];k<adjStart[p+1];k++ {
+        x := l.Rng.Exponential(1/transmission)
+        if x < d {
+            send(t+DT.Time(x*scale+0.5), p, adj[k], INFECT, l)
+        }
+    }
+}
+
+
+func send(t DT.Time, from int, to int, typ int32, l *Local.LocalData) {
+    /* the initial events use the identifiers [0, ninitial) */
+    ev := DT.CreateEvent(Sim.NewId(ninitial, &l.LpState.(*sirState).NextId, l), t, DT.Info{from,to,typ})
+    Sim.NoticeEvent(ev, DT.Pid(owner[to]), l)
+}
+
+
+/* a value of d in clock ticks, at least one */
+func ticks(d *Random.Dist, rng *Random.RNG) DT.Time {
+    t := DT.Time(d.Draw(rng)*scale + 0.5)
+    if t < 1 {
+        t = 1
+    }
+    return t
+}
+
+
+/* commit hook: st is the state before ev, so it tells if ev changed the status */
+func CommitEvent(ev *DT.Event, st DT.LPstate, l *Local.LocalData) {
+    pre := st.(*sirState).Status[local[ev.Type.To]]
+    lp := l.IndexLP
+    day := int(float64(ev.Time)/scale)
+    if day > days {
+        day = days
+    }
+
+    switch ev.Type.Flag {
+        case INFECT:
+        if pre == SUSCEPTIBLE {
+            infections[lp][day]++
+            if latent == nil {
+                onsets[lp][day]++
+            }
+        }
+        case ONSET:
+        onsets[lp][day]++
+        case RECOVER:
+        recoveries[lp][day]++
+    }
+}
+
+
+/* number of persons in each status at the end of every day */
+func report(filename string) {
+    var inf, ons, rec int64
+
+    file,err := os.Open(filename, os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0644)
+    if err != nil {
+        fmt.Println("GO-WARP, error writing the daily statistics:",err)
+        return
+    }
+    w := bufio.NewWriter(file)
+    fmt.Fprint(w, "day,susceptible,exposed,infectious,removed,new_infections\n")
+
+    var peak, peakday int64
+    for d:=0;d<days;d++ {
+        var newinf int64 = 0
+        for lp:=0;lp<lpnum;lp++ {
+            newinf += infections[lp][d]
+            ons += onsets[lp][d]
+            rec += recoveries[lp][d]
+        }
+        inf += newinf
+        fmt.Fprintf(w, "%d,%d,%d,%d,%d,%d\n", d, int64(npersons)-inf, inf-ons, ons-rec, rec, newinf)
+        if ons-rec > peak {
+            peak = ons-rec
+            peakday = int64(d)
+        }
+    }
+    w.Flush()
+    file.Close()
+
+    fmt.Println("Infected persons:",inf,"of",npersons)
+    fmt.Println("Peak of infectious persons:",peak,"on day",peakday)
+}
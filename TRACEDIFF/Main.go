@@ -0,0 +1,63 @@
+/*
+	Committed event trace comparison tool for GO-WARP
+	http://pads.cs.unibo.it
+  
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+
+package main
+
+/*
+ * Compares two committed event logs written by CommitLog and reports the
+ * first point of divergence. The exit status is 0 if the logs are equal
+ */
+
+import(
+    "../src/CommitLog"
+    "fmt"
+    "flag"
+    "os"
+)
+
+const usage = "TraceDiff.out [-ids] log1 log2"
+
+var checkIds = flag.Bool("ids", false, "compare also the event identifiers")
+
+
+func main() {
+    flag.Parse()
+    if flag.NArg() != 2 {
+        fmt.Println(usage)
+        os.Exit(2)
+    }
+
+    same,n,r1,r2,err := CommitLog.Compare(flag.Arg(0), flag.Arg(1), *checkIds)
+    if err != nil {
+        fmt.Println("TRACEDIFF, error:",err)
+        os.Exit(2)
+    }
+    if same {
+        fmt.Println("The logs are equal:",n,"committed events")
+        os.Exit(0)
+    }
+    fmt.Println("The logs diverge at committed event",n)
+    show(flag.Arg(0), r1)
+    show(flag.Arg(1), r2)
+    os.Exit(1)
+}
+
+
+func show(name string, r *CommitLog.Record) {
+    if r != nil {
+        fmt.Println("  ",name,": time",r.Time,"source",r.Src,"destination",r.Dst,"id",r.Id,"hash",fmt.Sprintf("%08x",r.Hash))
+    } else {
+        fmt.Println("  ",name,": end of the log")
+    }
+}
@@ -0,0 +1,223 @@
+/*
+	GO-WARP: a Time Warp simulator written in Go
+	http://pads.cs.unibo.it
+
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+
+package Check
+
+/*
+ * Debug mode: the kernel checks the Time Warp invariants while the
+ * simulation runs. Every violation is reported with the state of the LP
+ * that found it, the run goes on so that all of them can be seen. The
+ * checks are:
+ *  - GVT never decreases and never exceeds the minimum computed by Gvt
+ *  - no committed event (before GVT) is ever rolled back
+ *  - ProcessedEvents and MsgSent stay sorted by time
+ *  - every anti-message annihilates exactly one positive message, or
+ *    waits for it in AntiMsg2Annihilate
+ *  - no message is in transit at termination
+ * The checks exist only if the constant DEBUG is true: the compiler
+ * removes the code guarded by "if Check.DEBUG && Check.Enabled", and
+ * Sim.Run rejects the debug mode otherwise. Enabled is set by Setup
+ */
+
+import(
+    "./Communication"
+    "./Const"
+    "./DT"
+    "./Gvt"
+    "./Local"
+    "./Log"
+    "./Shared"
+    "./Stats"
+    "fmt"
+    "os"
+    "sync"
+    list "container/list"
+)
+
+const DEBUG = false
+
+var(
+    Enabled bool = false
+    lock sync.Mutex
+    violations []int64		// per LP
+    committed []DT.Time		// events before this time are committed, per LP
+    unmatched []int		// anti-messages left to annihilate at the end, per LP
+)
+
+
+func Setup(lpn int) {
+    violations = make([]int64, lpn)
+    committed = make([]DT.Time, lpn)
+    unmatched = make([]int, lpn)
+    Enabled = true
+}
+
+
+/* logs a violation found by LP data with its context */
+func report(data *Local.LocalData, what string) {
+    lock.Lock()
+    defer lock.Unlock()
+
+    violations[data.IndexLP]++
+    Log.LP(data.IndexLP, data.SimTime, data.Gvt).Error("check failed", "what", what,
+        "lastgvt", Gvt.Last(), "committed", committed[data.IndexLP], "state", Shared.State[data.IndexLP],
+        "evalgvt", data.GvtFlag, "processed", data.N_PROCESSED, "pending", data.FutureEvents.Len(),
+        "minpending", data.FutureEvents.GetMinTime(), "history", span(data.ProcessedEvents),
+        "sent", span(data.MsgSent), "outgoing", span(data.OutgoingMsg), "antimsgs", span(data.AntiMsg2Annihilate))
+}
+
+
+/* length and time range of a history list */
+func span(L *list.List) string {
+    if L.Len() == 0 {
+        return "0"
+    }
+    return fmt.Sprintf("%d [%d,%d]", L.Len(), L.Front().Value.(DT.Elem).GetTime(), L.Back().Value.(DT.Elem).GetTime())
+}
+
+
+/*
+ * LP data is going to set GVT to gvt: it must not exceed the minimum of
+ * the local minima of the last evaluation, unless the LPs have been
+ * paused at gvt (see Gvt.Raise)
+ */
+func SetGvt(data *Local.LocalData, gvt DT.Time) {
+    if gvt < data.Gvt {
+        report(data, fmt.Sprintf("GVT decreases from %d to %d", data.Gvt, gvt))
+    }
+    min,raised := Gvt.Minimum()
+    if gvt > min && gvt != raised {
+        report(data, fmt.Sprintf("GVT %d exceeds the global minimum %d", gvt, min))
+    }
+}
+
+
+/* LP data has committed the events before t */
+func Commit(data *Local.LocalData, t DT.Time) {
+    if t > committed[data.IndexLP] {
+        committed[data.IndexLP] = t
+    }
+}
+
+
+/* LP data is rolling back to t */
+func Rollback(data *Local.LocalData, t DT.Time) {
+    c := committed[data.IndexLP]
+    if t < c {
+        report(data, fmt.Sprintf("rollback to %d undoes events committed before %d", t, c))
+    }
+}
+
+
+/* the list L of LP data must be sorted by time */
+func Sorted(data *Local.LocalData, name string, L *list.List) {
+    el := L.Front()
+    if el == nil {
+        return
+    }
+    t := el.Value.(DT.Elem).GetTime()
+    for el = el.Next(); el != nil; el = el.Next() {
+        t1 := el.Value.(DT.Elem).GetTime()
+        if t1 < t {
+            report(data, fmt.Sprintf("%s not sorted: %d after %d", name, t1, t))
+            return
+        }
+        t = t1
+    }
+}
+
+
+/*
+ * LP data has handled the anti-message anti: either it has annihilated its
+ * positive message, that was pending exactly once, or the positive message
+ * is in transit and anti waits for it in AntiMsg2Annihilate
+ */
+func Annihilate(data *Local.LocalData, anti *DT.Event, annihilated bool) {
+    n := 0
+    evs := data.FutureEvents.Events()
+    for i:=0;i<len(evs);i++ {
+        if DT.Cancels(anti, &evs[i]) {
+            n++
+        }
+    }
+    if n > 0 {
+        report(data, fmt.Sprintf("anti-message %d at %d leaves %d matching pending events", anti.Id, anti.Time, n))
+    }
+    if !annihilated && !queued(data.AntiMsg2Annihilate, anti) {
+        report(data, fmt.Sprintf("anti-message %d at %d matches no pending event and is not queued", anti.Id, anti.Time))
+    }
+}
+
+
+func queued(L *list.List, anti *DT.Event) bool {
+    for el:=L.Front();el!=nil;el=el.Next() {
+        e := el.Value.(DT.Event)
+        if e.Id == anti.Id && e.Time == anti.Time && e.Type.From == anti.Type.From && e.Type.To == anti.Type.To {
+            return true
+        }
+    }
+    return false
+}
+
+
+/* LP data has stopped */
+func Stop(data *Local.LocalData) {
+    unmatched[data.IndexLP] = data.AntiMsg2Annihilate.Len()
+}
+
+
+/*
+ * checks the termination invariants, after all the LPs have stopped: every
+ * anti-message has annihilated a positive message and no message is in
+ * transit. The messages left in the channels are consumed, only the abort
+ * messages are expected. Returns an error if any check has failed
+ */
+func Final() os.Error {
+    var total int64 = 0
+
+    tot := Stats.Total()
+    n := 0
+    for i:=0;i<len(unmatched);i++ {
+        n += unmatched[i]
+    }
+    if n > 0 || tot.AntiSent != tot.Annihilated {
+        Log.Error("check failed", "what", "anti-messages not annihilated", "sent", tot.AntiSent,
+            "annihilated", tot.Annihilated, "unmatched", n)
+        total++
+    }
+
+    for i:=0;i<len(violations);i++ {
+        for Communication.QueueLen(DT.Pid(i)) > 0 {
+            m := Communication.BlockingReceive(DT.Pid(i))
+            if m.Ev.Time != Const.ABORTMSG {
+                Log.For(DT.Pid(i)).Error("check failed", "what", "message in transit at termination",
+                    "from", m.Sender, "event", m.Ev.Id, "at", m.Ev.Time, "flag", m.Ev.Type.Flag)
+                total++
+            }
+        }
+    }
+    sent,recv := Communication.Count()
+    if sent != recv {
+        Log.Error("check failed", "what", "messages not received", "sent", sent, "received", recv)
+        total++
+    }
+
+    for i:=0;i<len(violations);i++ {
+        total += violations[i]
+    }
+    if total > 0 {
+        return os.NewError(fmt.Sprint(total, " invariant violations"))
+    }
+    return nil
+}
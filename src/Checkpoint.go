@@ -0,0 +1,342 @@
+/*
+	GO-WARP: a Time Warp simulator written in Go
+	http://pads.cs.unibo.it
+  
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+
+package Checkpoint
+
+/*
+ * Whole-simulation checkpoints. When all the LPs are idle at the checkpoint
+ * time (and no message is in transit) each LP writes its own file, named
+ * <file>.<LP index>, with the pending events, the anti-messages still to be
+ * annihilated, the model state, the random number streams and the counters. Every event
+ * before the checkpoint time is committed at that point. Shared.CheckpointTime is
+ * changed by Wait while the LPs run, they read it with Time
+ */
+
+import(
+    "./Const"
+    "./DT"
+    "./Inject"
+    "./Local"
+    "./Random"
+    "./Shared"
+    "./Stats"
+    "fmt"
+    "os"
+    "bufio"
+    "strings"
+    "strconv"
+    "sync"
+)
+
+const MAGIC = "GO-WARP CHECKPOINT 4"
+
+var(
+    lock sync.Mutex
+    arrived int = 0
+    release chan int = make(chan int)
+    started DT.Time = 0	// checkpoint time already started
+)
+
+
+func fileName(file string, pid DT.Pid) string {
+    return fmt.Sprintf("%s.%d", file, pid)
+}
+
+
+func writeEvent(w *bufio.Writer, ev *DT.Event) {
+    fmt.Fprintf(w, "%d %d %d %d %d\n", ev.Id, ev.Time, ev.Type.From, ev.Type.To, ev.Type.Flag)
+}
+
+
+/* writes the checkpoint of LP data at time t */
+func Save(file string, t DT.Time, data *Local.LocalData) os.Error {
+    f,err := os.Open(fileName(file, data.IndexLP), os.O_WRONLY|os.O_CREAT|os.O_TRUNC, Const.PERM)
+    if err != nil {
+        return err
+    }
+    w := bufio.NewWriter(f)
+    s := &Stats.Lp[data.IndexLP]
+
+    fmt.Fprintln(w, MAGIC)
+    fmt.Fprintln(w, "lp", data.IndexLP, Shared.Lpnum)
+    fmt.Fprintln(w, "time", t)
+    fmt.Fprintln(w, "counters", data.N_PROCESSED, Shared.N_rollback[data.IndexLP], Shared.N_gvt, Inject.NextId())
+    fmt.Fprintln(w, "stats", s.Processed, s.Committed, s.RolledBack, s.Rollbacks, s.AntiSent, s.AntiRecv, s.Annihilated, s.GvtRounds)
+
+    fmt.Fprintln(w, "streams", len(data.Streams))
+    for e,rng := range data.Streams {
+        fmt.Fprintln(w, e, rng.Kind, rng.Seed, rng.Prev, rng.S[0], rng.S[1], rng.S[2], rng.S[3], rng.S[4], rng.S[5])
+    }
+    if data.LpState != nil {
+        fmt.Fprintf(w, "state %x\n", data.LpState.Marshal())
+    } else {
+        fmt.Fprintln(w, "state none")
+    }
+
+    evs := data.FutureEvents.Events()
+    fmt.Fprintln(w, "events", len(evs))
+    for i:=0;i<len(evs);i++ {
+        writeEvent(w, &evs[i])
+    }
+
+    fmt.Fprintln(w, "antimsgs", data.AntiMsg2Annihilate.Len())
+    for el:=data.AntiMsg2Annihilate.Front();el!=nil;el=el.Next() {
+        ev := el.Value.(DT.Event)
+        writeEvent(w, &ev)
+    }
+
+    err = w.Flush()
+    f.Close()
+    return err
+}
+
+
+/*
+ * returns true only for the first LP that finds all the LPs idle at the
+ * current checkpoint time, that LP starts the checkpoint
+ */
+func Start() bool {
+    var ret bool = false
+
+    lock.Lock()
+    if started != Shared.CheckpointTime {
+        started = Shared.CheckpointTime
+        ret = true
+    }
+    lock.Unlock()
+    return ret
+}
+
+
+/* the time of the next checkpoint, 0 if none. It can be called from any goroutine */
+func Time() DT.Time {
+    lock.Lock()
+    defer lock.Unlock()
+    return Shared.CheckpointTime
+}
+
+
+/*
+ * every LP calls Wait after writing its checkpoint, nobody goes on until
+ * all the LPs have done it
+ */
+func Wait() {
+    lock.Lock()
+    arrived++
+    if arrived < Shared.Lpnum {
+        lock.Unlock()
+        <- release
+        return
+    }
+
+    arrived = 0
+    if Shared.CheckpointPeriod > 0 {
+        Shared.CheckpointTime += Shared.CheckpointPeriod
+    } else {
+        Shared.CheckpointTime = 0
+    }
+    for i:=1;i<Shared.Lpnum;i++ {
+        release <- 0
+    }
+    lock.Unlock()
+}
+
+
+type reader struct {
+    rd *bufio.Reader
+    line int
+    err os.Error
+}
+
+
+/* reads the next line, that must start with key (if not empty) */
+func (r *reader) fields(key string) []string {
+    if r.err != nil {
+        return nil
+    }
+    line,err := r.rd.ReadString('\n')
+    r.line++
+    if err != nil {
+        r.err = err
+        return nil
+    }
+    f := strings.Fields(line)
+    if key != "" && (len(f) == 0 || f[0] != key) {
+        r.err = os.NewError(fmt.Sprintf("line %d: %s expected", r.line, key))
+        return nil
+    }
+    return f
+}
+
+
+func (r *reader) atoi(s string) int64 {
+    if r.err != nil {
+        return 0
+    }
+    n,err := strconv.Atoi64(s)
+    if err != nil {
+        r.err = err
+    }
+    return n
+}
+
+
+func (r *reader) event() DT.Event {
+    var ev DT.Event
+
+    f := r.fields("")
+    if len(f) != 5 {
+        if r.err == nil {
+            r.err = os.NewError(fmt.Sprintf("line %d: malformed event", r.line))
+        }
+        return ev
+    }
+    ev.Id = int32(r.atoi(f[0]))
+    ev.Time = DT.Time(r.atoi(f[1]))
+    ev.Type.From = int(r.atoi(f[2]))
+    ev.Type.To = int(r.atoi(f[3]))
+    ev.Type.Flag = int32(r.atoi(f[4]))
+    return ev
+}
+
+
+func unhex(s string) ([]byte, os.Error) {
+    b := make([]byte, len(s)/2)
+    for i:=0;i<len(b);i++ {
+        n,err := strconv.Btoui64(s[2*i:2*i+2], 16)
+        if err != nil {
+            return nil, err
+        }
+        b[i] = byte(n)
+    }
+    return b, nil
+}
+
+
+/* reads the next line, that must be key followed by n-1 values */
+func (r *reader) record(key string, n int) []string {
+    f := r.fields(key)
+    if r.err == nil && len(f) != n {
+        r.err = os.NewError(fmt.Sprintf("line %d: %s wants %d values", r.line, key, n-1))
+        return nil
+    }
+    return f
+}
+
+
+/*
+ * restores the checkpoint of LP data, it must be called after Sim.Initialize
+ * and, for stateful models, after data.LpState has been allocated
+ */
+func Load(file string, data *Local.LocalData) os.Error {
+    f,err := os.Open(fileName(file, data.IndexLP), os.O_RDONLY, 0)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    r := &reader{bufio.NewReader(f), 0, nil}
+    s := &Stats.Lp[data.IndexLP]
+
+    line,err := r.rd.ReadString('\n')
+    r.line++
+    if err != nil || strings.TrimSpace(line) != MAGIC {
+        return os.NewError(fileName(file, data.IndexLP) + " is not a checkpoint")
+    }
+
+    lp := r.fields("lp")
+    if r.err == nil && (len(lp) != 3 || r.atoi(lp[1]) != int64(data.IndexLP) || r.atoi(lp[2]) != int64(Shared.Lpnum)) {
+        return os.NewError("the checkpoint was taken with a different number of LPs")
+    }
+
+    t := r.record("time", 2)
+    if r.err == nil {
+        data.SimTime = DT.Time(r.atoi(t[1]))
+        data.Gvt = data.SimTime
+    }
+
+    c := r.record("counters", 5)
+    if r.err == nil {
+        data.N_PROCESSED = int(r.atoi(c[1]))
+        Shared.N_rollback[data.IndexLP] = int(r.atoi(c[2]))
+        if data.IndexLP == 0 {
+            Shared.N_gvt = int(r.atoi(c[3]))
+            Inject.SetNextId(int32(r.atoi(c[4])))
+        }
+    }
+
+    c = r.record("stats", 9)
+    if r.err == nil {
+        s.Processed = r.atoi(c[1])
+        s.Committed = r.atoi(c[2])
+        s.RolledBack = r.atoi(c[3])
+        s.Rollbacks = r.atoi(c[4])
+        s.AntiSent = r.atoi(c[5])
+        s.AntiRecv = r.atoi(c[6])
+        s.Annihilated = r.atoi(c[7])
+        s.GvtRounds = r.atoi(c[8])
+    }
+
+    c = r.record("streams", 2)
+    if r.err == nil {
+        n := int(r.atoi(c[1]))
+        for i:=0;i<n && r.err==nil;i++ {
+            f := r.fields("")
+            if len(f) == 10 {
+                rng := new(Random.RNG)
+                rng.Kind = int(r.atoi(f[1]))
+                rng.Seed = r.atoi(f[2])
+                rng.Prev = r.atoi(f[3])
+                for j:=0;j<6;j++ {
+                    rng.S[j] = r.atoi(f[4+j])
+                }
+                data.Streams[int(r.atoi(f[0]))] = rng
+            } else if r.err == nil {
+                r.err = os.NewError(fmt.Sprintf("line %d: malformed stream", r.line))
+            }
+        }
+    }
+
+    c = r.record("state", 2)
+    if r.err == nil && c[1] != "none" {
+        if data.LpState == nil {
+            return os.NewError("the checkpoint contains a model state but LpState is nil")
+        }
+        b,err := unhex(c[1])
+        if err == nil {
+            err = data.LpState.Unmarshal(b)
+        }
+        if err != nil {
+            return err
+        }
+    }
+
+    c = r.record("events", 2)
+    if r.err == nil {
+        n := int(r.atoi(c[1]))
+        for i:=0;i<n && r.err==nil;i++ {
+            ev := r.event()
+            data.NewEvent(&ev)
+        }
+    }
+
+    c = r.record("antimsgs", 2)
+    if r.err == nil {
+        n := int(r.atoi(c[1]))
+        for i:=0;i<n && r.err==nil;i++ {
+            DT.Insert(r.event(), data.AntiMsg2Annihilate)
+        }
+    }
+    return r.err
+}
+
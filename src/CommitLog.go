@@ -0,0 +1,282 @@
+/*
+	GO-WARP: a Time Warp simulator written in Go
+	http://pads.cs.unibo.it
+  
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+
+package CommitLog
+
+/*
+ * Canonical log of the committed events. Each LP writes the events released
+ * by fossil collection in a temporary file under Const.LOGDIR, Close merges
+ * the files in a single log sorted by (time, source, destination, payload
+ * hash, id). Each line is: time source destination id hash. Compare finds
+ * the first divergence between two logs
+ */
+
+import(
+    "./Const"
+    "./DT"
+    "./Workload"
+    "fmt"
+    "os"
+    "bufio"
+    "sort"
+    "strings"
+    "strconv"
+    "hash/crc32"
+)
+
+type Record struct {
+    Time DT.Time
+    Src int
+    Dst int
+    Id int32
+    Hash uint32
+}
+
+type records []Record
+
+var(
+    Enabled bool = false
+    filename string
+    files []*os.File
+    out []*bufio.Writer
+)
+
+
+/* hash of the event payload: the model data in Type and the payload of a loaded event, see Workload */
+func Hash(ev *DT.Event) uint32 {
+    p := Workload.Payload(ev)
+    b := make([]byte, 12+len(p))
+
+    put := func(pos int, v int32) {
+        for i:=0;i<4;i++ {
+            b[pos+i] = byte(v >> uint(8*i))
+        }
+    }
+    put(0, int32(ev.Type.From))
+    put(4, int32(ev.Type.To))
+    put(8, ev.Type.Flag)
+    copy(b[12:], p)
+    return crc32.ChecksumIEEE(b)
+}
+
+
+func NewRecord(ev *DT.Event) Record {
+    return Record{ev.Time, ev.Type.From, ev.Type.To, ev.Id, Hash(ev)}
+}
+
+
+/* canonical order of the committed events */
+func (r *Record) Less(r1 *Record) bool {
+    if r.Time != r1.Time { return r.Time < r1.Time }
+    if r.Src != r1.Src { return r.Src < r1.Src }
+    if r.Dst != r1.Dst { return r.Dst < r1.Dst }
+    if r.Hash != r1.Hash { return r.Hash < r1.Hash }
+    return r.Id < r1.Id
+}
+
+
+func (r *Record) String() string {
+    return fmt.Sprintf("%d %d %d %d %08x", r.Time, r.Src, r.Dst, r.Id, r.Hash)
+}
+
+
+func Parse(line string) (Record, os.Error) {
+    var r Record
+
+    f := strings.Fields(line)
+    if len(f) != 5 {
+        return r, os.NewError("malformed committed event: " + line)
+    }
+    t,err := strconv.Atoi(f[0])
+    if err == nil { r.Src,err = strconv.Atoi(f[1]) }
+    if err == nil { r.Dst,err = strconv.Atoi(f[2]) }
+    var id int
+    if err == nil { id,err = strconv.Atoi(f[3]) }
+    var h uint64
+    if err == nil { h,err = strconv.Btoui64(f[4], 16) }
+    r.Time = DT.Time(t)
+    r.Id = int32(id)
+    r.Hash = uint32(h)
+    return r, err
+}
+
+
+/* same event, the identifiers are compared only if ids is set */
+func (r *Record) Equal(r1 *Record, ids bool) bool {
+    if ids && r.Id != r1.Id {
+        return false
+    }
+    return r.Time == r1.Time && r.Src == r1.Src && r.Dst == r1.Dst && r.Hash == r1.Hash
+}
+
+
+/*
+ * compares two logs. If they are equal n is the number of events, otherwise
+ * it is the position (from 1) of the first divergence and r1, r2 are the
+ * records found there, nil at the end of a log
+ */
+func Compare(name1 string, name2 string, ids bool) (same bool, n int, r1 *Record, r2 *Record, err os.Error) {
+    f1,err := os.Open(name1, os.O_RDONLY, 0)
+    if err != nil {
+        return
+    }
+    defer f1.Close()
+    f2,err := os.Open(name2, os.O_RDONLY, 0)
+    if err != nil {
+        return
+    }
+    defer f2.Close()
+    rd1 := bufio.NewReader(f1)
+    rd2 := bufio.NewReader(f2)
+
+    read := func(rd *bufio.Reader, name string) (*Record, os.Error) {
+        line,err := rd.ReadString('\n')
+        if err == os.EOF && len(line) == 0 {
+            return nil, nil
+        }
+        r,err := Parse(line)
+        if err != nil {
+            return nil, os.NewError(fmt.Sprint(name, " line ", n, ": ", err))
+        }
+        return &r, nil
+    }
+
+    for n=1;;n++ {
+        if r1,err = read(rd1, name1); err != nil {
+            return
+        }
+        if r2,err = read(rd2, name2); err != nil {
+            return
+        }
+        if r1 == nil && r2 == nil {
+            return true, n-1, nil, nil, nil
+        }
+        if r1 == nil || r2 == nil || !r1.Equal(r2, ids) {
+            return
+        }
+    }
+    return
+}
+
+
+func (r records) Len() int { return len(r) }
+func (r records) Less(i, j int) bool { return r[i].Less(&r[j]) }
+func (r records) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+
+
+func tmpName(pid int) string {
+    return fmt.Sprintf("%scommit.%d.tmp", Const.LOGDIR, pid)
+}
+
+
+/* enables the log, it will be written in fname by Close */
+func Setup(lpn int, fname string) os.Error {
+    var err os.Error
+
+    filename = fname
+    files = make([]*os.File, lpn)
+    out = make([]*bufio.Writer, lpn)
+
+    for i:=0;i<lpn;i++ {
+        files[i],err = os.Open(tmpName(i), os.O_RDWR|os.O_CREAT|os.O_TRUNC, Const.PERM)
+        if err != nil {
+            return err
+        }
+        out[i] = bufio.NewWriter(files[i])
+    }
+    Enabled = true
+    return nil
+}
+
+
+/* writes a batch of events committed by LP pid */
+func Write(pid DT.Pid, evs []DT.Event) {
+    r := make(records, len(evs))
+
+    for i:=0;i<len(evs);i++ {
+        r[i] = NewRecord(&evs[i])
+    }
+    sort.Sort(r)
+    for i:=0;i<len(r);i++ {
+        fmt.Fprintln(out[pid], r[i].String())
+    }
+}
+
+
+/* merges the per-LP files in the canonical log */
+func Close() os.Error {
+    if !Enabled {
+        return nil
+    }
+    Enabled = false
+
+    n := len(files)
+    in := make([]*bufio.Reader, n)
+    head := make([]Record, n)
+    valid := make([]bool, n)
+    defer func() {
+        for i:=0;i<n;i++ {
+            files[i].Close()
+            os.Remove(tmpName(i))
+        }
+    }()
+
+    next := func(i int) os.Error {
+        line,err := in[i].ReadString('\n')
+        if err == os.EOF && len(line) == 0 {
+            valid[i] = false
+            return nil
+        }
+        head[i],err = Parse(line)
+        valid[i] = err == nil
+        return err
+    }
+
+    for i:=0;i<n;i++ {
+        out[i].Flush()
+        files[i].Seek(0, 0)
+        in[i] = bufio.NewReader(files[i])
+        if err := next(i); err != nil {
+            return err
+        }
+    }
+
+    file,err := os.Open(filename, os.O_WRONLY|os.O_CREAT|os.O_TRUNC, Const.PERM)
+    if err != nil {
+        return err
+    }
+    w := bufio.NewWriter(file)
+
+    Loop: for {
+        min := -1
+        for i:=0;i<n;i++ {
+            if valid[i] && (min == -1 || head[i].Less(&head[min])) {
+                min = i
+            }
+        }
+        if min == -1 {
+            break Loop
+        }
+        fmt.Fprintln(w, head[min].String())
+        if err = next(min); err != nil {
+            break Loop
+        }
+    }
+
+    if err == nil {
+        err = w.Flush()
+    }
+    file.Close()
+    return err
+}
@@ -11,65 +11,520 @@
 	Computer Science Department, University of Bologna, Italy
 */
 
+
+
 package Communication
 
+/*
+ * Message passing among the LPs. Sim calls Send, Receive and
+ * BlockingReceive, that count the messages for the quiescence detection
+ * and hand them to the current Transport. The default transport is a
+ * buffered Go channel per LP, other backends (sockets, shared memory, a
+ * simulated network) are set with SetTransport. The fault injection wraps
+ * the current transport, the deterministic mode replaces it
+ */
+
 import(
     "./DT"
-    "fmt"
+    "./Const"
+    "./Random"
+    "./Sched"
+    "os"
+    "strings"
+    "strconv"
+    list "container/list"
 )
 
 const MAXBUFFER = 10000
-const MAXALLOCN = 1
+
+/*
+ * A transport delivers the messages to the LPs. The messages from a sender
+ * to a receiver must be delivered in send order, as on a channel, and none
+ * is lost: the kernel only tolerates an anti-message that overtakes its
+ * positive message (see Faults.AntiFirst). Send must not block the sender
+ * for long, the LPs of a run may send to each other. The methods are
+ * called concurrently by the LPs, Receive and BlockingReceive of an LP
+ * only by that LP
+ */
+type Transport interface {
+    Send(msg *DT.Message)
+    Receive(recvid DT.Pid) *DT.Message		// nil if no message is waiting
+    BlockingReceive(recvid DT.Pid) *DT.Message	// waits for a message, a transport that cannot wait returns nil
+    Len(recvid DT.Pid) int			// messages waiting for recvid
+}
+
 var(
-    Chanptr *[]chan DT.Message
+    transport Transport
     lock chan int = make(chan int)
-    allocations int = 0
+
+    /* messages sent and received by each LP, used to detect quiescence */
+    Sent []int64
+    Received []int64
+    ServerSent int64 = 0	// sent by the server (the injector)
+
+    /* fault injection, nil if disabled */
+    faults *Faults = nil
+
+    /* per LP counters of the fault injection */
+    Delayed []int64		// messages held back
+    Reordered []int64		// messages delivered before an older one of another sender
+    Overtaken []int64		// anti-messages delivered before their positive message
+
+    /* deterministic mode */
+    sched bool = false
 )
 
 
+/*
+ * Fault injection, for testing the rollback paths of the kernel. The
+ * messages taken from the transport of an LP are held back, released in
+ * batches and delivered out of order, as decided by a seeded stream per LP.
+ * As required by the Transport contract the messages of a sender keep their
+ * order, only the messages of different senders are reordered; the one
+ * exception is AntiFirst. The quiescence detection counts a message as
+ * received only when it is delivered, so a held message keeps the
+ * simulation going
+ */
+type Faults struct {
+    Seed int64
+    Delay float64		// probability that a message is held back
+    Hold int			// maximum receive calls a message is held
+    Batch int			// the messages are released in groups of Batch, 0 or 1 for no batching
+    Reorder bool		// the released messages of different senders are delivered in random order
+    AntiFirst bool		// a held anti-message is delivered in place of its held positive message
+    Rollbacks float64		// probability of a forced rollback after each event, used by Sim
+}
+
+type heldMsg struct {
+    msg DT.Message
+    wait int			// receive calls before it can be released
+    age int
+    release bool
+    first bool			// oldest held message of its sender
+}
+
+
+
+/*
+ * the counters of nChan LPs and the default transport, buffered channels.
+ * Called at the setup of each run: the transport, the counters, the fault
+ * injection and the deterministic mode of the previous run are dropped
+ */
 func AllocateChans(nChan int) {
+    transport = NewChannels(nChan, MAXBUFFER)
+    Sent = make([]int64, nChan)
+    Received = make([]int64, nChan)
+    ServerSent = 0
+    faults = nil
+    Delayed = nil
+    Reordered = nil
+    Overtaken = nil
+    sched = false
+}
+
 
-    if allocations >= MAXALLOCN { return }
+/*
+ * replaces the transport, after AllocateChans and before the LPs start.
+ * The fault injection wraps the transport set before EnableFaults
+ */
+func SetTransport(t Transport) os.Error {
+    if sched {
+        return os.NewError("the deterministic mode has its own transport")
+    }
+    transport = t
+    return nil
+}
 
-    ch := make ([]chan DT.Message, nChan)	// this is to make the array
+
+/* the transport in use */
+func GetTransport() Transport {
+    return transport
+}
+
+
+/* the in-process transport: a buffered Go channel per LP */
+type Channels struct {
+    ch []chan DT.Message
+}
+
+func NewChannels(nChan int, buffer int) *Channels {
+    c := &Channels{make([]chan DT.Message, nChan)}	// this is to make the array
 
     for i:=0; i<nChan; i++ {
-        ch[i]=make(chan DT.Message, MAXBUFFER)	// this is to make the chans
+        c.ch[i] = make(chan DT.Message, buffer)	// this is to make the chans
+    }
+    return c
+}
+
+func (c *Channels) Send(msg *DT.Message) {
+    c.ch[msg.Receiver] <- *msg
+}
+
+func (c *Channels) Receive(recvid DT.Pid) *DT.Message {
+    msg,ok := <- c.ch[recvid]
+    if !ok {
+        return nil
+    }
+    return &msg
+}
+
+func (c *Channels) BlockingReceive(recvid DT.Pid) *DT.Message {
+    msg := <- c.ch[recvid]
+    return &msg
+}
+
+func (c *Channels) Len(recvid DT.Pid) int {
+    return len(c.ch[recvid])
+}
+
+
+
+/*
+ * the fault injection parameters are a comma separated list of key=value
+ * (seed, delay, hold, batch, rollbacks) and flags (reorder, antifirst),
+ * e.g. "seed=3,delay=0.3,hold=10,reorder,antifirst"
+ */
+func ParseFaults(spec string) (*Faults, os.Error) {
+    var err os.Error
+    f := &Faults{1, 0.1, 8, 0, false, false, 0}
+
+    for _,item := range strings.Split(spec, ",", -1) {
+        kv := strings.Split(strings.TrimSpace(item), "=", 2)
+        if len(kv) == 1 {
+            switch kv[0] {
+                case "reorder":
+                f.Reorder = true
+                case "antifirst":
+                f.AntiFirst = true
+                case "":
+                default:
+                return nil, os.NewError("unknown fault "+kv[0])
+            }
+            continue
+        }
+        switch kv[0] {
+            case "seed":
+            f.Seed,err = strconv.Atoi64(kv[1])
+            case "delay":
+            f.Delay,err = strconv.Atof64(kv[1])
+            case "hold":
+            f.Hold,err = strconv.Atoi(kv[1])
+            case "batch":
+            f.Batch,err = strconv.Atoi(kv[1])
+            case "rollbacks":
+            f.Rollbacks,err = strconv.Atof64(kv[1])
+            default:
+            return nil, os.NewError("unknown fault "+kv[0])
+        }
+        if err != nil {
+            return nil, os.NewError("bad value for "+kv[0]+": "+kv[1])
+        }
+    }
+    if f.Hold < 1 {
+        return nil, os.NewError("hold must be at least 1")
+    }
+    return f, nil
+}
+
+
+
+/*
+ * turns on the fault injection, after AllocateChans (and SetTransport) and
+ * before the LPs start
+ */
+func EnableFaults(f *Faults) {
+    n := len(Sent)
+
+    ft := &faulty{transport, f, make([]*list.List, n), make([]*Random.RNG, n)}
+    Delayed = make([]int64, n)
+    Reordered = make([]int64, n)
+    Overtaken = make([]int64, n)
+    for i:=0;i<n;i++ {
+        ft.held[i] = list.New()
+        ft.rng[i] = Random.RandStream(f.Seed, int64(i))
+    }
+    faults = f
+    transport = ft
+}
+
+
+func FaultsEnabled() bool {
+    return faults != nil
+}
+
+
+/* total of the fault injection counters */
+func FaultCount() (delayed int64, reordered int64, overtaken int64) {
+    for i:=0;i<len(Delayed);i++ {
+        delayed += Delayed[i]
+        reordered += Reordered[i]
+        overtaken += Overtaken[i]
+    }
+    return
+}
+
+
+/*
+ * deterministic mode: the messages are queued instead of sent on the
+ * transport and the scheduler decides which one is delivered
+ */
+func EnableSched() {
+    n := len(Sent)
+
+    q := &queues{make([]*list.List, n)}
+    for i:=0;i<n;i++ {
+        q.queued[i] = list.New()
     }
-    Chanptr = &ch
-    allocations++
+    transport = q
+    sched = true
 }
 
 
 /* Send a message to destination */
 func Send(msg *DT.Message) {
-    (*Chanptr)[msg.Receiver] <- *msg
+    if msg.Sender == Const.SERVERID {
+        ServerSent++
+    } else {
+        Sent[msg.Sender]++
+    }
+    transport.Send(msg)
 }
 
 
 func Receive(recvid DT.Pid) *DT.Message {
-    var msg DT.Message
-    var ret *DT.Message
-    var ok bool = false
+    msg := transport.Receive(recvid)
+    if msg != nil {
+        Received[recvid]++
+    }
+    return msg
+}
 
-    msg,ok = <- (*Chanptr)[recvid]
-    if ok {
-        ret = &msg
-    } else {
-        ret = nil
+/* blocking receive, in deterministic mode it returns nil instead of blocking */
+func BlockingReceive(recvid DT.Pid) *DT.Message {
+    return IdleReceive(recvid, nil)
+}
+
+
+/*
+ * blocking receive of an idle LP: wake (if not nil) is called when a
+ * message is taken, before it is counted as received. The LP must leave
+ * the idle state there, otherwise another LP could find all of them idle
+ * and nothing in transit while this one holds the message
+ */
+func IdleReceive(recvid DT.Pid, wake func()) *DT.Message {
+    msg := transport.BlockingReceive(recvid)
+    if msg != nil {
+        if wake != nil {
+            wake()
+        }
+        Received[recvid]++
     }
+    return msg
+}
+
 
-    return ret
+/* number of messages waiting for recvid, the held ones included */
+func QueueLen(recvid DT.Pid) int {
+    return transport.Len(recvid)
 }
 
-/* blocking receive */
-func BlockingReceive(recvid DT.Pid) *DT.Message {
-    select {
-        case msg := <- (*Chanptr)[recvid]:
-            return &msg
+
+/* the fault injection around another transport */
+type faulty struct {
+    inner Transport
+    f *Faults
+    held []*list.List		// messages taken from inner and not yet delivered, per LP
+    rng []*Random.RNG
+}
+
+func (t *faulty) Send(msg *DT.Message) {
+    t.inner.Send(msg)
+}
+
+func (t *faulty) Receive(recvid DT.Pid) *DT.Message {
+    return t.receive(recvid, false)
+}
+
+func (t *faulty) BlockingReceive(recvid DT.Pid) *DT.Message {
+    return t.receive(recvid, true)
+}
+
+func (t *faulty) Len(recvid DT.Pid) int {
+    return t.inner.Len(recvid) + t.held[recvid].Len()
+}
+
+
+/*
+ * receive with fault injection: the new messages are moved from the inner
+ * transport to the held list, then one of the released messages that are
+ * the oldest of their sender is delivered. A blocking receive delivers a
+ * held message even if not released, it blocks only if nothing is held
+ */
+func (t *faulty) receive(recvid DT.Pid, block bool) *DT.Message {
+    h := t.held[recvid]
+    rng := t.rng[recvid]
+    faults := t.f
+
+    for {
+        msg := t.inner.Receive(recvid)
+        if msg == nil {
+            break
+        }
+        t.hold(recvid, *msg)
     }
-    fmt.Println("GO-WARP: receive error!")
-    return nil
+    if h.Len() == 0 {
+        if !block {
+            return nil
+        }
+        return t.inner.BlockingReceive(recvid)
+    }
+
+    /* the messages that have waited enough are released, in groups if batching */
+    eligible := 0
+    expired := false
+    for el := h.Front(); el != nil; el = el.Next() {
+        m := el.Value.(*heldMsg)
+        m.age++
+        if m.age > m.wait {
+            eligible++
+        }
+        if m.age > faults.Hold {
+            expired = true
+        }
+    }
+    if faults.Batch <= 1 || eligible >= faults.Batch || expired {
+        for el := h.Front(); el != nil; el = el.Next() {
+            m := el.Value.(*heldMsg)
+            if m.age > m.wait || m.age > faults.Hold {
+                m.release = true
+            }
+        }
+    }
+
+    /* a message can be delivered only after the older ones of its sender */
+    seen := make(map[DT.Pid]bool)
+    var candidates int = 0
+    for el := h.Front(); el != nil; el = el.Next() {
+        m := el.Value.(*heldMsg)
+        _,older := seen[m.msg.Sender]
+        m.first = !older
+        seen[m.msg.Sender] = true
+        if m.first && (m.release || block) {
+            candidates++
+        }
+    }
+    if candidates == 0 {
+        return nil
+    }
+
+    k := 0
+    if faults.Reorder {
+        k = int(rng.RandIntUniform(0, int32(candidates-1)))
+    }
+    var sel *list.Element
+    for el := h.Front(); el != nil; el = el.Next() {
+        m := el.Value.(*heldMsg)
+        if m.first && (m.release || block) {
+            if k == 0 {
+                sel = el
+                break
+            }
+            k--
+        }
+    }
+    if sel != h.Front() {
+        Reordered[recvid]++
+    }
+
+    if faults.AntiFirst {
+        m := &sel.Value.(*heldMsg).msg
+        if m.Ev.Time >= 0 && m.Ev.Type.Flag != Const.ANTIMSG {
+            for el := h.Front(); el != nil; el = el.Next() {
+                a := &el.Value.(*heldMsg).msg
+                if a.Ev.Type.Flag == Const.ANTIMSG && a.Sender == m.Sender && a.Ev.Id == -m.Ev.Id && a.Ev.Time == m.Ev.Time {
+                    sel = el
+                    Overtaken[recvid]++
+                    break
+                }
+            }
+        }
+    }
+
+    msg := sel.Value.(*heldMsg).msg
+    h.Remove(sel)
+    return &msg
+}
+
+
+/* a message enters the held list, with probability Delay it waits some receive calls */
+func (t *faulty) hold(recvid DT.Pid, msg DT.Message) {
+    rng := t.rng[recvid]
+    m := &heldMsg{msg, 0, 0, false, false}
+
+    if rng.RandFloat() < t.f.Delay {
+        m.wait = 1 + int(rng.RandIntUniform(0, int32(t.f.Hold-1)))
+        Delayed[recvid]++
+    }
+    t.held[recvid].PushBack(m)
+}
+
+
+/* the transport of the deterministic mode */
+type queues struct {
+    queued []*list.List		// messages not yet delivered, per LP, in send order
+}
+
+func (t *queues) Send(msg *DT.Message) {
+    t.queued[msg.Receiver].PushBack(*msg)
+}
+
+/*
+ * the scheduler chooses the sender among the ones with queued messages, the
+ * messages of a sender keep their order as on a channel. Returns nil if
+ * nothing is queued
+ */
+func (t *queues) Receive(recvid DT.Pid) *DT.Message {
+    q := t.queued[recvid]
+    if q.Len() == 0 {
+        return nil
+    }
+
+    /* the oldest message of each sender */
+    first := make([]*list.Element, 0, q.Len())
+    seen := make(map[DT.Pid]bool)
+    for el:=q.Front(); el!=nil; el=el.Next() {
+        sender := el.Value.(DT.Message).Sender
+        if !seen[sender] {
+            seen[sender] = true
+            first = first[0:len(first)+1]
+            first[len(first)-1] = el
+        }
+    }
+
+    el := first[Sched.Choose(len(first))]
+    msg := el.Value.(DT.Message)
+    q.Remove(el)
+    return &msg
+}
+
+/* it does not block, all the LPs run on one goroutine */
+func (t *queues) BlockingReceive(recvid DT.Pid) *DT.Message {
+    return t.Receive(recvid)
+}
+
+func (t *queues) Len(recvid DT.Pid) int {
+    return t.queued[recvid].Len()
+}
+
+
+/* total number of messages sent and received */
+func Count() (sent int64, recv int64) {
+    for i:=0;i<len(Sent);i++ {
+        sent += Sent[i]
+        recv += Received[i]
+    }
+    sent += ServerSent
+    return sent, recv
 }
 
 
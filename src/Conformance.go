@@ -0,0 +1,282 @@
+/*
+	GO-WARP: a Time Warp simulator written in Go
+	http://pads.cs.unibo.it
+  
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+
+package Conformance
+
+/*
+ * Conformance kit for the models. The model is run once as the reference,
+ * a Time Warp run with one LP and GOMAXPROCS=1 that never rolls back. It
+ * is not a sequential simulator: it shares the commit, the state saving
+ * and the commit hooks with the other runs, so a bug there is not
+ * detected. Then it is run by Time Warp with several numbers
+ * of LPs and GOMAXPROCS values, with and without forced rollbacks and
+ * fault injection: every run must commit the same events and write the
+ * same statistics as the reference. The model must accept the -commitlog,
+ * -stats, -rollbacks and -faults options, its statistics files
+ * <prefix>.<name>.csv are compared too
+ */
+
+import(
+    "./CommitLog"
+    "fmt"
+    "os"
+    "io"
+    "io/ioutil"
+    "exec"
+    "json"
+    "strings"
+    "strconv"
+)
+
+const LPARG = "%lp"		// in Config.Args, it stands for the number of LPs
+
+type Config struct {
+    Model string		// model executable
+    Args []string		// model options and arguments
+    Lps []int
+    Procs []int
+    Rollbacks float64		// probability of the forced rollbacks, 0 to skip those runs
+    Faults string		// fault injection parameters, empty to skip those runs
+    Dir string			// where the logs of the runs are written
+    Verbose bool		// prints each run
+}
+
+/* an execution of the model */
+type Run struct {
+    Lps int
+    Procs int
+    Rollbacks float64
+    Faults string
+}
+
+
+func (r *Run) String() string {
+    s := fmt.Sprintf("LPs %d GOMAXPROCS %d", r.Lps, r.Procs)
+    if r.Rollbacks > 0 {
+        s += fmt.Sprintf(" forced rollbacks %g", r.Rollbacks)
+    }
+    if r.Faults != "" {
+        s += " faults "+r.Faults
+    }
+    return s
+}
+
+
+/* returns nil if every run conforms to the reference, otherwise the first difference */
+func Check(c *Config) os.Error {
+    ref := &Run{1, 1, 0, ""}
+    refp := c.Dir+"conform.ref"
+    if err := execute(c, ref, refp); err != nil {
+        return os.NewError("reference run: "+err.String())
+    }
+
+    /* plain, forced rollbacks and fault injection runs */
+    modes := []Run{Run{}, Run{Rollbacks: c.Rollbacks}, Run{Faults: c.Faults}}
+    n := 0
+    for _,p := range c.Procs {
+        for _,l := range c.Lps {
+            for i,m := range modes {
+                if (i == 1 && m.Rollbacks == 0) || (i == 2 && m.Faults == "") {
+                    continue
+                }
+                n++
+                run := &Run{l, p, m.Rollbacks, m.Faults}
+                prefix := fmt.Sprintf("%sconform.%d", c.Dir, n)
+                err := execute(c, run, prefix)
+                if err == nil {
+                    err = compare(refp, prefix)
+                }
+                if err != nil {
+                    return os.NewError(run.String()+": "+err.String())
+                }
+                if c.Verbose {
+                    fmt.Println("CONFORMANCE:",run.String(),"OK")
+                }
+            }
+        }
+    }
+    return nil
+}
+
+
+/* runs the model, its output goes in <prefix>.out and the committed events in <prefix>.log */
+func execute(c *Config, r *Run, prefix string) os.Error {
+    if c.Verbose {
+        fmt.Println("CONFORMANCE: running",r.String())
+    }
+
+    argv := make([]string, len(c.Args)+9)
+    argv[0] = c.Model
+    argv[1] = "-commitlog"
+    argv[2] = prefix+".log"
+    argv[3] = "-stats"
+    argv[4] = prefix
+    argv[5] = "-rollbacks"
+    argv[6] = strconv.Ftoa64(r.Rollbacks, 'g', -1)
+    na := 7
+    if r.Faults != "" {
+        argv[7] = "-faults"
+        argv[8] = r.Faults
+        na = 9
+    }
+    for _,a := range c.Args {
+        if a == LPARG {
+            a = strconv.Itoa(r.Lps)
+        }
+        argv[na] = a
+        na++
+    }
+    argv = argv[0:na]
+
+    env := os.Environ()
+    envv := make([]string, len(env)+1)
+    ne := 0
+    for i:=0;i<len(env);i++ {
+        if !strings.HasPrefix(env[i], "GOMAXPROCS=") {
+            envv[ne] = env[i]
+            ne++
+        }
+    }
+    envv[ne] = fmt.Sprintf("GOMAXPROCS=%d", r.Procs)
+    envv = envv[0:ne+1]
+
+    out,err := os.Open(prefix+".out", os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0644)
+    if err != nil {
+        return err
+    }
+    defer out.Close()
+
+    cmd,err := exec.Run(c.Model, argv, envv, "", exec.DevNull, exec.Pipe, exec.MergeWithStdout)
+    if err != nil {
+        return err
+    }
+    io.Copy(out, cmd.Stdout)
+    w,err := cmd.Wait(0)
+    if err != nil {
+        return err
+    }
+    if !w.Exited() || w.ExitStatus() != 0 {
+        return os.NewError(fmt.Sprint("exit status ", w.ExitStatus(), ", see ", prefix+".out"))
+    }
+    return nil
+}
+
+
+/* committed events, committed events count and model statistics of a run against the reference */
+func compare(ref string, run string) os.Error {
+    same,n,r1,r2,err := CommitLog.Compare(ref+".log", run+".log", false)
+    if err != nil {
+        return err
+    }
+    if !same {
+        return os.NewError(fmt.Sprintf("first diverging committed event %d: reference %s, run %s",
+            n, show(r1), show(r2)))
+    }
+
+    c1,err := committed(ref+".json")
+    if err != nil {
+        return err
+    }
+    c2,err := committed(run+".json")
+    if err != nil {
+        return err
+    }
+    if c1 != c2 {
+        return os.NewError(fmt.Sprintf("committed events: reference %d, run %d", c1, c2))
+    }
+
+    for _,name := range modelStats(ref) {
+        if err := compareFiles(ref+name, run+name); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+
+func show(r *CommitLog.Record) string {
+    if r == nil {
+        return "end of the log"
+    }
+    return "("+r.String()+")"
+}
+
+
+/* the total of committed events in a statistics file written by Stats.WriteJSON */
+func committed(filename string) (int64, os.Error) {
+    b,err := ioutil.ReadFile(filename)
+    if err != nil {
+        return 0, err
+    }
+    var m map[string]interface{}
+    if err = json.Unmarshal(b, &m); err != nil {
+        return 0, os.NewError(filename+": "+err.String())
+    }
+    tot,ok := m["total"].(map[string]interface{})
+    if !ok {
+        return 0, os.NewError(filename+": totals not found")
+    }
+    n,ok := tot["committed"].(float64)
+    if !ok {
+        return 0, os.NewError(filename+": committed events not found")
+    }
+    return int64(n), nil
+}
+
+
+/* suffixes of the statistics files written by the model, <prefix>.<name>.csv */
+func modelStats(prefix string) []string {
+    dir := "."
+    base := prefix
+    if i := strings.LastIndex(prefix, "/"); i >= 0 {
+        dir = prefix[0:i+1]
+        base = prefix[i+1:]
+    }
+    f,err := os.Open(dir, os.O_RDONLY, 0)
+    if err != nil {
+        return nil
+    }
+    names,_ := f.Readdirnames(-1)
+    f.Close()
+
+    suffixes := make([]string, len(names))
+    n := 0
+    for _,name := range names {
+        if strings.HasPrefix(name, base+".") && strings.HasSuffix(name, ".csv") && name != base+".csv" {
+            suffixes[n] = name[len(base):]
+            n++
+        }
+    }
+    return suffixes[0:n]
+}
+
+
+func compareFiles(name1 string, name2 string) os.Error {
+    b1,err := ioutil.ReadFile(name1)
+    if err != nil {
+        return err
+    }
+    b2,err := ioutil.ReadFile(name2)
+    if err != nil {
+        return err
+    }
+    l1 := strings.Split(string(b1), "\n", -1)
+    l2 := strings.Split(string(b2), "\n", -1)
+    for i:=0;i<len(l1) || i<len(l2);i++ {
+        if i >= len(l1) || i >= len(l2) || l1[i] != l2[i] {
+            return os.NewError(fmt.Sprintf("%s and %s differ at line %d", name1, name2, i+1))
+        }
+    }
+    return nil
+}
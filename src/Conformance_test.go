@@ -0,0 +1,81 @@
+/*
+	GO-WARP: a Time Warp simulator written in Go
+	http://pads.cs.unibo.it
+
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+
+package Conformance
+
+/*
+ * the models are run from builds/, where make puts them: a test whose
+ * model has not been built is skipped
+ */
+
+import(
+    "os"
+    "strings"
+    "testing"
+)
+
+const(
+    BUILDS = "../builds/"
+    LOGS = "../logs/conformance_test/"
+)
+
+
+func config(t *testing.T, model string, args []string) *Config {
+    if _,err := os.Stat(BUILDS+model); err != nil {
+        t.Logf("%s not built, skipped", BUILDS+model)
+        return nil
+    }
+    if err := os.MkdirAll(LOGS, 0755); err != nil {
+        t.Fatalf("creating %s: %s", LOGS, err)
+    }
+    return &Config{
+        Model: BUILDS+model,
+        Args: args,
+        Lps: []int{1, 2, 4},
+        Procs: []int{1, 2},
+        Rollbacks: 0.05,
+        Faults: "delay=0.3,hold=10,batch=3,reorder,antifirst",
+        Dir: LOGS,
+    }
+}
+
+
+func TestPHOLD(t *testing.T) {
+    c := config(t, "Main.out", []string{"-conf", "../PHOLD/phold.conf", LPARG, "64"})
+    if c == nil {
+        return
+    }
+    if err := Check(c); err != nil {
+        t.Errorf("PHOLD does not conform: %s", err)
+    }
+}
+
+
+/* CONFORM/Unsafe.go changes a counter that is not saved, a rollback does not restore it */
+func TestUnsafe(t *testing.T) {
+    c := config(t, "Unsafe.out", []string{LPARG})
+    if c == nil {
+        return
+    }
+    c.Lps = []int{2}
+    c.Faults = ""
+
+    err := Check(c)
+    if err == nil {
+        t.Fatalf("the unsafe model conforms")
+    }
+    if strings.Index(err.String(), "first diverging committed event") < 0 {
+        t.Errorf("the failure does not show the first diverging committed event: %s", err)
+    }
+}
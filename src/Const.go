@@ -25,6 +25,9 @@ const(
     GVTEVAL = -6
     ABORTMSG = -7
     RBMSG = -8
+    CKPTMSG = -9	// all the LPs are idle at the checkpoint time
+    PAUSEMSG = -10	// all the LPs are idle at the pause time
+    INJECTMSG = -11	// the injector has been closed
 
 /* different types of ack messages */
     MINE = -1		// the sender of the ACK assumes the responsibility for the message in the GVT evaluation
@@ -49,6 +52,9 @@ const(
 /* server managment constants */
     NOTIME = -2
     ERR = -1
+
+/* event identifiers: the models use [0, INJECTIDS), see Sim.NewId, the injector the ones above */
+    INJECTIDS = 1 << 30
 )
 
 /* possible process states */
@@ -0,0 +1,454 @@
+/*
+	GO-WARP: a Time Warp simulator written in Go
+	http://pads.cs.unibo.it
+
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+
+package Control
+
+/*
+ * Control of a running simulation: pause, resume, run up to a simulated
+ * time or for a number of committed events. A request sets the pause time,
+ * that limits the horizon of the LPs as a checkpoint time does. When all
+ * the LPs are idle there and no message is in transit Sim sends PAUSEMSG,
+ * each LP commits every event before the pause time and waits in Wait: the
+ * simulation is paused at a consistent point. The requests block the
+ * caller until the pause is reached, or return an error if the simulation
+ * ends before. The same requests can be made over HTTP, see Handle.
+ * A stop (external, or when the predicate of the model holds at a GVT) is
+ * a pause where the LPs do not wait: every event before the stop time is
+ * committed, the ones processed after it are discarded and the run ends.
+ * In deterministic mode the LPs run on the caller of Sim.Run, that would
+ * block in Wait: only the stops are available there
+ */
+
+import(
+    "./DT"
+    "./Gvt"
+    "./Sched"
+    "./Shared"
+    "./Stats"
+    "fmt"
+    "os"
+    "http"
+    "strconv"
+    "sync"
+    "time"
+)
+
+const PATH = "/control/"
+
+var(
+    Enabled bool = false	// the requests are served over HTTP, see Handle
+    lock sync.Mutex
+    until DT.Time = 0		// pause time, 0 if none
+    target int64 = 0		// pause when this many events are committed, 0 if none
+    started bool = false	// the pause at until has been started
+    paused bool = false
+    ended bool = false
+    stopping bool = false	// the pause at until ends the run
+    stopped bool = false
+    predicate func(gvt DT.Time, committed int64) bool	// nil if none
+    arrived int = 0
+    pausedAt int64 = 0		// wall clock time of the last pause
+    pausedFor int64 = 0		// ns spent paused, up to the last resume
+    release chan int = make(chan int)
+    reached chan bool = make(chan bool)	// closed at the next pause or at the end
+)
+
+var ErrEnded = os.NewError("the simulation has ended")
+var ErrRunning = os.NewError("the simulation is not paused")
+var ErrScheduled = os.NewError("the simulation cannot pause in deterministic mode")
+var ErrNotLive = os.NewError("the LPs do not publish their statistics, see Stats.Live")
+
+
+/* the pause time, 0 if no pause has been requested */
+func Time() DT.Time {
+    return until
+}
+
+
+/*
+ * returns true only for the first LP that finds all the LPs idle at the
+ * pause time, that LP starts the pause
+ */
+func Start() bool {
+    var ret bool = false
+
+    lock.Lock()
+    if !started && until > 0 {
+        started = true
+        ret = true
+    }
+    lock.Unlock()
+    return ret
+}
+
+
+/*
+ * every LP calls Wait after committing, it returns at the next Resume or
+ * Stop. True if the LP must stop, in that case it does not wait
+ */
+func Wait() bool {
+    lock.Lock()
+    arrived++
+    if arrived == Shared.Lpnum {
+        Gvt.Raise(until)
+        if stopping {
+            stopped = true
+            ended = true
+        } else {
+            paused = true
+            pausedAt = time.Nanoseconds()
+        }
+        close(reached)
+    }
+    if stopping {
+        lock.Unlock()
+        return true
+    }
+    lock.Unlock()
+    <- release
+
+    lock.Lock()
+    defer lock.Unlock()
+    return stopping
+}
+
+
+/* true if the run is stopping at the pause time, the LPs discard the events after it */
+func Stopping() bool {
+    return stopping
+}
+
+
+/* the stop time, ok is false if the run has not been stopped */
+func Stopped() (t DT.Time, ok bool) {
+    lock.Lock()
+    defer lock.Unlock()
+    return until, stopped
+}
+
+
+/* the simulation has ended, the pending requests fail */
+func End() {
+    lock.Lock()
+    if !ended {
+        ended = true
+        if !paused {
+            close(reached)
+        }
+    }
+    lock.Unlock()
+}
+
+
+/*
+ * called after each fossil collection: when enough events are committed
+ * the simulation is paused just after the current GVT, as Pause does,
+ * when the predicate holds it is stopped there. The committed events are
+ * counted in the statistics published by the LPs
+ */
+func Committed(gvt DT.Time) {
+    if target == 0 && predicate == nil {
+        return
+    }
+    lock.Lock()
+    defer lock.Unlock()
+    if started || ended || (until > 0 && gvt >= until) {
+        return
+    }
+    n := Stats.Sum(Stats.Snapshot()).Committed
+    if predicate != nil && !stopping && predicate(gvt, n) {
+        stopping = true
+        until = gvt+1
+    } else if target > 0 && n >= target {
+        target = 0
+        until = gvt+1
+    }
+}
+
+
+/*
+ * registers the termination predicate of the model, after Sim.Setup and
+ * before the run. It is called with the GVT and the committed events after
+ * each fossil collection of an LP (the others may not have committed up to
+ * the GVT yet, the count is a lower bound): when it returns true the run
+ * stops just after that GVT. It must not block
+ */
+func StopWhen(f func(gvt DT.Time, committed int64) bool) {
+    predicate = f
+    Stats.Live = true
+}
+
+
+/* waits for the pause requested with the lock held, that is released */
+func wait() (DT.Time, os.Error) {
+    ch := reached
+    lock.Unlock()
+
+    <- ch
+
+    lock.Lock()
+    defer lock.Unlock()
+    if !paused && !stopped {
+        return 0, ErrEnded
+    }
+    return until, nil
+}
+
+
+/* ns spent paused before the last resume, the real-time mode does not count them */
+func PausedTime() int64 {
+    lock.Lock()
+    defer lock.Unlock()
+    return pausedFor
+}
+
+
+/* lets the paused LPs go on with pause time t, with the lock held */
+func restart(t DT.Time) {
+    if paused {
+        pausedFor += time.Nanoseconds()-pausedAt
+    }
+    until = t
+    started = false
+    paused = false
+    arrived = 0
+    reached = make(chan bool)
+    for i:=0;i<Shared.Lpnum;i++ {
+        release <- 0
+    }
+}
+
+
+/*
+ * pauses the simulation at the earliest consistent point, just after the
+ * last GVT. Returns the pause time
+ */
+func Pause() (DT.Time, os.Error) {
+    if Sched.Enabled {
+        return 0, ErrScheduled
+    }
+    lock.Lock()
+    if ended {
+        lock.Unlock()
+        return 0, ErrEnded
+    }
+    if paused {
+        lock.Unlock()
+        return until, nil
+    }
+    t := Gvt.Last()+1
+    if !started && (until == 0 || t < until) {
+        until = t
+    }
+    return wait()
+}
+
+
+/* the paused simulation goes on, up to the end */
+func Resume() os.Error {
+    lock.Lock()
+    defer lock.Unlock()
+    if !paused {
+        return ErrRunning
+    }
+    target = 0
+    restart(0)
+    return nil
+}
+
+
+/*
+ * the simulation goes on up to time t, i.e. all the events before t are
+ * committed, and pauses there. A running simulation can only be paused
+ * earlier than requested before
+ */
+func RunUntil(t DT.Time) (DT.Time, os.Error) {
+    if Sched.Enabled {
+        return 0, ErrScheduled
+    }
+    lock.Lock()
+    if ended {
+        lock.Unlock()
+        return 0, ErrEnded
+    }
+    if paused {
+        if t <= until {
+            lock.Unlock()
+            return 0, os.NewError(fmt.Sprint("the simulation is already paused at ", until))
+        }
+        restart(t)
+    } else if started || (until > 0 && t >= until) || t <= Gvt.Last() {
+        lock.Unlock()
+        return 0, os.NewError(fmt.Sprint("the running simulation cannot pause at ", t))
+    } else {
+        until = t
+    }
+    return wait()
+}
+
+
+/*
+ * the simulation goes on until at least n more events are committed, then
+ * it pauses just after the GVT reached. The committed events are counted in the
+ * statistics published by the LPs at each GVT, Stats.Live must be set
+ * before the run (Metrics.Start and Handle set it)
+ */
+func RunEvents(n int64) (DT.Time, os.Error) {
+    if Sched.Enabled {
+        return 0, ErrScheduled
+    }
+    if !Stats.Live {
+        return 0, ErrNotLive
+    }
+    lock.Lock()
+    if ended {
+        lock.Unlock()
+        return 0, ErrEnded
+    }
+    target = Stats.Sum(Stats.Snapshot()).Committed + n
+    if paused {
+        restart(0)
+    }
+    return wait()
+}
+
+
+/*
+ * stops the simulation at the earliest consistent point, as Pause, or at
+ * the current pause time if paused. Returns the stop time
+ */
+func Stop() (DT.Time, os.Error) {
+    lock.Lock()
+    for started && !paused && !ended {
+        /* a pause in progress cannot become a stop, the LPs check it in Wait */
+        ch := reached
+        lock.Unlock()
+        <- ch
+        lock.Lock()
+    }
+    if ended {
+        lock.Unlock()
+        return 0, ErrEnded
+    }
+    stopping = true
+    if paused {
+        t := until
+        stopped = true
+        ended = true
+        restart(t)
+        lock.Unlock()
+        return t, nil
+    }
+    t := Gvt.Last()+1
+    if !started && (until == 0 || t < until) {
+        until = t
+    }
+    return wait()
+}
+
+
+/* one more committed event */
+func Step() (DT.Time, os.Error) {
+    return RunEvents(1)
+}
+
+
+/* state of the simulation: running, paused, stopped or ended */
+func Status() (state string, t DT.Time) {
+    lock.Lock()
+    defer lock.Unlock()
+
+    switch {
+        case stopped:
+        return "stopped", until
+        case ended:
+        return "ended", Gvt.Last()
+        case paused:
+        return "paused", until
+    }
+    return "running", Gvt.Last()
+}
+
+
+/*
+ * registers the HTTP handlers of the requests under PATH, on the default
+ * server (e.g. the one started by Metrics.Start), before the run:
+ * status, pause, resume, until?t=T, events?n=N, step, stop
+ */
+func Handle() {
+    Enabled = true
+    Stats.Live = true
+    http.HandleFunc(PATH+"status", serveStatus)
+    http.HandleFunc(PATH+"pause", func(c *http.Conn, req *http.Request) {
+        t,err := Pause()
+        reply(c, t, err)
+    })
+    http.HandleFunc(PATH+"resume", func(c *http.Conn, req *http.Request) {
+        err := Resume()
+        if err != nil {
+            reply(c, 0, err)
+            return
+        }
+        serveStatus(c, req)
+    })
+    http.HandleFunc(PATH+"until", func(c *http.Conn, req *http.Request) {
+        t,err := strconv.Atoi(req.FormValue("t"))
+        if err != nil {
+            reply(c, 0, os.NewError("bad time "+req.FormValue("t")))
+            return
+        }
+        t1,err := RunUntil(DT.Time(t))
+        reply(c, t1, err)
+    })
+    http.HandleFunc(PATH+"events", func(c *http.Conn, req *http.Request) {
+        n,err := strconv.Atoi64(req.FormValue("n"))
+        if err != nil || n < 1 {
+            reply(c, 0, os.NewError("bad number of events "+req.FormValue("n")))
+            return
+        }
+        t,err := RunEvents(n)
+        reply(c, t, err)
+    })
+    http.HandleFunc(PATH+"step", func(c *http.Conn, req *http.Request) {
+        t,err := Step()
+        reply(c, t, err)
+    })
+    http.HandleFunc(PATH+"stop", func(c *http.Conn, req *http.Request) {
+        t,err := Stop()
+        if err != nil {
+            reply(c, 0, err)
+            return
+        }
+        c.SetHeader("Content-Type", "text/plain")
+        fmt.Fprintln(c, "stopped", t)
+    })
+}
+
+
+func serveStatus(c *http.Conn, req *http.Request) {
+    state,t := Status()
+    c.SetHeader("Content-Type", "text/plain")
+    fmt.Fprintf(c, "state %s\ntime %d\ngvt %d\ncommitted %d\n", state, t, Gvt.Last(), Stats.Sum(Stats.Snapshot()).Committed)
+}
+
+
+func reply(c *http.Conn, t DT.Time, err os.Error) {
+    c.SetHeader("Content-Type", "text/plain")
+    if err != nil {
+        c.WriteHeader(http.StatusConflict)
+        fmt.Fprintln(c, "error", err)
+        return
+    }
+    fmt.Fprintln(c, "paused", t)
+}
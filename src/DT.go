@@ -16,6 +16,7 @@ package DT /* Data Types */
 import(
     "./Const"
     "fmt"
+    "os"
     list "container/list"
 )
 
@@ -45,6 +46,13 @@ type Event struct {
     Type Info
 }
 
+/* the model state of an LP, it is written in the checkpoints */
+type LPstate interface{
+    Copy() LPstate		// used by state saving
+    Marshal() []byte
+    Unmarshal(b []byte) os.Error
+}
+
 /* interface useful as Elem of a List */
 type Elem interface{
     GetTime() Time
@@ -222,6 +230,16 @@ func CreateMessage(sendr Pid, recvr Pid, e Event) *Message {
 }
 
 
+/*
+ * true if anti is the anti-message of ev. The identifier of a re-executed
+ * event can be the one of the cancelled copy, so the time and the entities
+ * must match too
+ */
+func Cancels(anti *Event, ev *Event) bool {
+    return anti.Id == -ev.Id && anti.Time == ev.Time && anti.Type.From == ev.Type.From && anti.Type.To == ev.Type.To
+}
+
+
 /* type Event implements Elem interface */
 func (ev Event) GetTime() Time {
     return ev.Time
@@ -18,6 +18,7 @@ import(
     "./DT"
     "./Shared"
     "sync"
+    "time"
 )
 
 var(
@@ -25,7 +26,13 @@ var(
     localMin []DT.Time
     gvt DT.Time
     gvtFlag bool
+    minimum DT.Time		// of the local minima of the last evaluation
+    raised DT.Time		// the last pause time
+    at int64			// wall clock time of the last GVT
     lock sync.Mutex
+
+    /* minimum time of the senders outside the LPs (the injector), nil if none */
+    ServerMin func() DT.Time
 )
 
 const MAXTIME = 1 << 31 -1
@@ -38,6 +45,7 @@ func Setup(lpnum int){
     lpNum = lpnum
     gvt = 0
     gvtFlag = false
+    at = time.Nanoseconds()
 }
 
 
@@ -88,7 +96,18 @@ func setGVT() {
             tmpMin = localMin[i]
         }
     }
+    if ServerMin != nil {
+        if m := ServerMin(); m < tmpMin && m != Const.NOTIME {
+            tmpMin = m
+        }
+    }
+    minimum = tmpMin
+    if tmpMin < raised {
+        /* the LPs can give a minimum below a pause time, nothing is pending there */
+        tmpMin = raised
+    }
     gvt = tmpMin
+    at = time.Nanoseconds()
 
     for i:=0;i<len(localMin);i++ {
         localMin[i] = EMPTY
@@ -98,6 +117,63 @@ func setGVT() {
 }
 
 
+/* excludes the GVT computation, e.g. while an external event is timestamped */
+func Lock() {
+    lock.Lock()
+}
+
+func Unlock() {
+    lock.Unlock()
+}
+
+
+/*
+ * all the LPs are paused at t and every event before it is committed, so
+ * t is a valid GVT
+ */
+func Raise(t DT.Time) {
+    lock.Lock()
+    if t > gvt {
+        gvt = t
+    }
+    raised = t
+    lock.Unlock()
+}
+
+
+/* ns elapsed since the last GVT was computed */
+func Age() int64 {
+    lock.Lock()
+    defer lock.Unlock()
+    return time.Nanoseconds()-at
+}
+
+
+/* completed GVT evaluations, it can be called from any goroutine */
+func Rounds() int {
+    lock.Lock()
+    defer lock.Unlock()
+    return Shared.N_gvt
+}
+
+
+/*
+ * the minimum of the local minima (and of ServerMin) of the last
+ * evaluation and the last pause time, see Check.SetGvt
+ */
+func Minimum() (min DT.Time, pause DT.Time) {
+    lock.Lock()
+    defer lock.Unlock()
+    return minimum, raised
+}
+
+
+/* the last GVT value, also during an evaluation */
+func Last() DT.Time {
+    return gvt
+}
+
+
 func GetGvt() DT.Time {
 
     if gvtFlag {
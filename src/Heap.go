@@ -23,6 +23,7 @@ import (
     "os"
     "./Const"
     "./DT"
+    "./Log"
 )
 
 const EVARRSIZE = 2000
@@ -119,6 +120,17 @@ func (heap *EventHeap) Insert(evptr *DT.Event) bool {
 }
 
 
+/* returns the number of events in the heap */
+func (heap *EventHeap) Len() int {
+    var n int = 0
+
+    for i:=1; i<len(*heap); i++ {
+        n += len(*(*heap)[i].events)
+    }
+    return n
+}
+
+
 /* returns the minimum time in the heap */
 func (heap *EventHeap) GetMinTime() DT.Time {
     if heap.IsEmpty() { return Const.NOTIME }
@@ -126,14 +138,32 @@ func (heap *EventHeap) GetMinTime() DT.Time {
 }
 
 
+/*
+ * order of the events with the same timestamp, it does not depend on the
+ * arrival order so that a re-execution after a rollback is identical
+ */
+func before(e1 *DT.Event, e2 *DT.Event) bool {
+    if e1.Type.From != e2.Type.From { return e1.Type.From < e2.Type.From }
+    if e1.Type.To != e2.Type.To { return e1.Type.To < e2.Type.To }
+    if e1.Type.Flag != e2.Type.Flag { return e1.Type.Flag < e2.Type.Flag }
+    return e1.Id < e2.Id
+}
+
+
 /* extracts the first event in the heap, that remains balanced */
 func (heap *EventHeap) ExtractHead() *DT.Event {
     var head DT.Event
     if heap.IsEmpty() { return nil }
 
-    head = (* (*heap)[1].events)[len(*(*heap)[1].events)-1]
+    evArr := (*heap)[1].events
+    head = (*evArr)[0]
+    for i:=1;i<len(*evArr);i++ {
+        if before(&(*evArr)[i], &head) {
+            head = (*evArr)[i]
+        }
+    }
     if !heap.Delete(&head) {
-        fmt.Println("GO-WARP: extracthead")
+        Log.Error("the head of the heap cannot be deleted", "event", head.Id, "at", head.Time)
         os.Exit(1)
         return nil
     }
@@ -332,6 +362,50 @@ func (heap *EventHeap) DeleteExternId(ev *DT.Event) DT.Event {
 }
 
 
+/*
+ * searches, deletes and returns the event cancelled by the anti-message
+ * anti (see DT.Cancels), only among the events with its time
+ */
+func (heap *EventHeap) DeleteCancelled(anti *DT.Event) DT.Event {
+    var ret DT.Event = DT.Event{Const.ERR,Const.ERR,DT.Info{0,0,0}}
+
+    i := heap.isPresent(anti.Time)
+    if i < 0 {
+        return ret
+    }
+    evArr := (*heap)[i].events
+    for j:=0;j<len(*evArr);j++ {
+        if DT.Cancels(anti, &(*evArr)[j]) {
+            ret = (*evArr)[j]
+            if len(*evArr) > 1 {
+                /* not by Delete, that takes the first event with the same identifier */
+                copy((*evArr)[j:], (*evArr)[j+1:])
+                *evArr = (*evArr)[0:len(*evArr)-1]
+            } else {
+                heap.Delete(&ret)
+            }
+            break
+        }
+    }
+    return ret
+}
+
+
+/* returns a copy of all the events in the heap */
+func (heap *EventHeap) Events() []DT.Event {
+    var ret []DT.Event = make([]DT.Event, heap.Len())
+    var n int = 0
+
+    for i:=1;i<len(*heap);i++ {
+        for j:=0;j<len(*(*heap)[i].events);j++ {
+            ret[n] = (*(*heap)[i].events)[j]
+            n++
+        }
+    }
+    return ret
+}
+
+
 func (heap *EventHeap) GetCopy() EventHeap {
     var ret EventHeap = InitializeHeap()
 
@@ -0,0 +1,270 @@
+/*
+	GO-WARP: a Time Warp simulator written in Go
+	http://pads.cs.unibo.it
+
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+
+package Inject
+
+/*
+ * External events injected in a running simulation (sensor feeds, user
+ * commands, recorded inputs). The injector is a sender outside the LPs,
+ * with identifier Const.SERVERID: an event is timestamped at or after the
+ * current GVT and sent through Communication to the LP that owns its
+ * destination entity (Shared.EntityMap). The receiver does not send an
+ * ACK to the injector but calls Ack, the injected events not yet received
+ * (or received by an LP that had already given its local minimum) are
+ * part of the GVT computation as the messages of an LP. While the
+ * injector is open the simulation does not end, even if all the LPs are
+ * idle
+ */
+
+import(
+    "./Communication"
+    "./Const"
+    "./DT"
+    "./Gvt"
+    "./Log"
+    "./Sched"
+    "./Shared"
+    "fmt"
+    "io"
+    "os"
+    "bufio"
+    "strings"
+    "strconv"
+    "sync"
+    list "container/list"
+)
+
+/* the injected events have identifiers from FIRSTID down to Const.INJECTIDS, out of the way of the models */
+const FIRSTID = 1 << 31 - 1
+
+type sent struct {
+    id int32
+    t DT.Time
+}
+
+var(
+    Enabled bool = false
+    open bool = false
+    lock sync.Mutex
+    nextId int32 = FIRSTID
+    outgoing *list.List		// injected events not yet received
+    acked *list.List		// received after the local minimum of the receiver was given
+    Injected int64 = 0
+)
+
+
+/*
+ * opens the injector, after Sim.Setup and before the LPs start. The model
+ * must set Shared.EntityMap
+ */
+func Open() os.Error {
+    if Shared.EntityMap == nil {
+        return os.NewError("the model does not support the event injection")
+    }
+    if Sched.Enabled {
+        return os.NewError("the event injection excludes the deterministic mode")
+    }
+    outgoing = list.New()
+    acked = list.New()
+    Gvt.ServerMin = gvtMin
+    Enabled = true
+    open = true
+    return nil
+}
+
+
+/* true until Close */
+func Opened() bool {
+    return open
+}
+
+
+/*
+ * injects an event for entity at time t, or at the current GVT if t is
+ * earlier. flag is the event type of the model. Returns the time given to
+ * the event
+ */
+func Inject(t DT.Time, entity int, flag int32) (DT.Time, os.Error) {
+    lp := Shared.EntityMap(entity)
+    if lp < 0 || int(lp) >= Shared.Lpnum {
+        return 0, os.NewError(fmt.Sprint("unknown entity ", entity))
+    }
+
+    /* no GVT can be computed while the event is timestamped and accounted */
+    Gvt.Lock()
+    lock.Lock()
+    if !open {
+        lock.Unlock()
+        Gvt.Unlock()
+        return 0, os.NewError("the injector is closed")
+    }
+    if g := Gvt.Last(); t < g {
+        t = g
+    }
+    if t >= Shared.EndTime {
+        lock.Unlock()
+        Gvt.Unlock()
+        return t, os.NewError(fmt.Sprint("time ", t, " is after the end of the simulation"))
+    }
+    if nextId < Const.INJECTIDS {
+        lock.Unlock()
+        Gvt.Unlock()
+        return t, os.NewError("the identifiers of the injected events are exhausted")
+    }
+    id := nextId
+    nextId--
+    outgoing.PushBack(sent{id, t})
+    Injected++
+    lock.Unlock()
+    Gvt.Unlock()
+
+    ev := DT.CreateEvent(id, t, DT.Info{Const.SERVERID, entity, flag})
+    Communication.Send(DT.CreateMessage(Const.SERVERID, lp, *ev))
+    return t, nil
+}
+
+
+/*
+ * the injected event id has been received, yours is true if the receiver
+ * had already given its local minimum for the running GVT evaluation
+ */
+func Ack(id int32, yours bool) {
+    lock.Lock()
+    defer lock.Unlock()
+
+    for el:=outgoing.Front(); el!=nil; el=el.Next() {
+        if el.Value.(sent).id == id {
+            if yours {
+                acked.PushBack(el.Value)
+            }
+            outgoing.Remove(el)
+            return
+        }
+    }
+    Log.Warn("ACK of an unknown injected event", "event", id)
+}
+
+
+/*
+ * minimum time of the injector for the GVT computation, called with the
+ * GVT lock held. The events acknowledged as YOURS are forgotten, from now
+ * on the receivers account for them
+ */
+func gvtMin() DT.Time {
+    lock.Lock()
+    defer lock.Unlock()
+
+    min := DT.Time(Const.NOTIME)
+    for _,L := range []*list.List{outgoing, acked} {
+        for el:=L.Front(); el!=nil; el=el.Next() {
+            t := el.Value.(sent).t
+            if min == Const.NOTIME || t < min {
+                min = t
+            }
+        }
+    }
+    acked.Init()
+    return min
+}
+
+
+/*
+ * closes the injector, the simulation can end. An LP is woken up with
+ * INJECTMSG, all of them may be idle waiting for the injected events.
+ * Called after the last Inject has returned
+ */
+func Close() {
+    lock.Lock()
+    defer lock.Unlock()
+
+    if !open {
+        return
+    }
+    open = false
+    ev := DT.CreateEvent(0, Const.INJECTMSG, DT.Info{0,0,0})
+    Communication.Send(DT.CreateMessage(Const.SERVERID, 0, *ev))
+}
+
+
+/* the identifier of the next injected event, saved in the checkpoints */
+func NextId() int32 {
+    lock.Lock()
+    defer lock.Unlock()
+    return nextId
+}
+
+
+/* restores the identifier of the next injected event from a checkpoint */
+func SetNextId(id int32) {
+    lock.Lock()
+    nextId = id
+    lock.Unlock()
+}
+
+
+/* the run has been stopped, no more events are injected */
+func End() {
+    lock.Lock()
+    open = false
+    lock.Unlock()
+}
+
+
+/*
+ * injects the events read from r, one per line: time entity [flag], where
+ * time "now" stands for the current GVT, everything after # is a comment.
+ * The injector is closed at the end of the input
+ */
+func Run(r io.Reader) os.Error {
+    defer Close()
+    rd := bufio.NewReader(r)
+
+    for n:=1;;n++ {
+        line,err := rd.ReadString('\n')
+        if err == os.EOF && len(line) == 0 {
+            return nil
+        } else if err != nil && err != os.EOF {
+            return err
+        }
+        err = nil
+        if i := strings.Index(line, "#"); i >= 0 {
+            line = line[0:i]
+        }
+        f := strings.Fields(line)
+        if len(f) == 0 {
+            continue
+        }
+        if len(f) > 3 || len(f) < 2 {
+            return os.NewError(fmt.Sprint("line ", n, ": time entity [flag] expected"))
+        }
+
+        var t, entity, flag int
+        if f[0] != "now" {
+            t,err = strconv.Atoi(f[0])
+        }
+        if err == nil {
+            entity,err = strconv.Atoi(f[1])
+        }
+        if err == nil && len(f) == 3 {
+            flag,err = strconv.Atoi(f[2])
+        }
+        if err != nil {
+            return os.NewError(fmt.Sprint("line ", n, ": ", err))
+        }
+        if _,err = Inject(DT.Time(t), entity, int32(flag)); err != nil {
+            return os.NewError(fmt.Sprint("line ", n, ": ", err))
+        }
+    }
+    return nil
+}
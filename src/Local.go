@@ -22,8 +22,11 @@ package Local
 import(
     "./DT"
     "./Heap"
-    "fmt"
+    "./Random"
+    "./State"
+    "./Log"
     "os"
+    "unsafe"
     list "container/list"
 )
 
@@ -40,6 +43,10 @@ type LocalData struct {
     Acked *list.List
     Pending bool
     GvtFlag bool
+    LpState DT.LPstate		// model state, nil for stateless models
+    Rng *Random.RNG		// stream of the entity of the event being processed
+    Streams map[int]*Random.RNG	// random number streams, one per entity
+    States *list.List		// saved states, for rollbacks
 }
 
 
@@ -63,14 +70,71 @@ func Initialize(i DT.Pid) *LocalData {
     d.AntiMsg2Annihilate = DT.NewList()
     d.OutgoingMsg = DT.NewList()
     d.Acked = DT.NewList()
+    d.Streams = make(map[int]*Random.RNG)
+    d.States = DT.NewList()
 
     return &d
 }
 
 
+/* approximate memory held in the history lists, in bytes */
+func (l *LocalData) HistoryBytes() int64 {
+    var elsize int64 = int64(unsafe.Sizeof(list.Element{}))
+    var evsize int64 = int64(unsafe.Sizeof(DT.Event{})) + elsize
+    var msgsize int64 = int64(unsafe.Sizeof(DT.TimedMessage{})) + elsize
+
+    n := int64(l.ProcessedEvents.Len() + l.AntiMsg2Annihilate.Len())*evsize
+    n += int64(l.MsgSent.Len() + l.OutgoingMsg.Len() + l.Acked.Len())*msgsize
+    n += int64(l.States.Len())*(int64(unsafe.Sizeof(State.State{})) + elsize)
+    return n
+}
+
+
+/* returns the random number stream of an entity, created on first use */
+func (l *LocalData) Stream(entity int, seed int64) *Random.RNG {
+    rng,ok := l.Streams[entity]
+    if !ok {
+        rng = Random.RandStream(seed, int64(entity)+1)
+        l.Streams[entity] = rng
+    }
+    return rng
+}
+
+
+/* saves the state before processing ev, selecting the stream of its entity */
+func (l *LocalData) SaveState(ev *DT.Event, seed int64) {
+    var lpvar DT.LPstate = nil
+
+    l.Rng = l.Stream(ev.Type.To, seed)
+    if l.LpState != nil {
+        lpvar = l.LpState.Copy()
+    }
+    DT.Insert(*State.CreateState(ev.Time, lpvar, ev.Type.To, *l.Rng), l.States)
+}
+
+
+/* restores the state saved before the first processed event with time >= t */
+func (l *LocalData) RestoreState(t DT.Time) {
+    el := l.States.Back()
+    Loop: for el != nil {
+        st := el.Value.(State.State)
+        if st.SimTime < t {
+            break Loop
+        }
+        *l.Streams[st.Entity] = st.Rng
+        if st.LpVar != nil {
+            l.LpState = st.LpVar
+        }
+        prev := el.Prev()
+        l.States.Remove(el)
+        el = prev
+    }
+}
+
+
 func (l *LocalData) NewEvent(ev *DT.Event) {
     if !l.FutureEvents.Insert(ev) {
-        fmt.Println("GO-WARP, ERROR: EVENT NOT INSERTED") 
+        Log.LP(l.IndexLP, l.SimTime, l.Gvt).Error("event not inserted", "event", ev.Id, "at", ev.Time)
         l.FutureEvents.Print()
         os.Exit(1)
     }
@@ -0,0 +1,220 @@
+/*
+	GO-WARP: a Time Warp simulator written in Go
+	http://pads.cs.unibo.it
+
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+
+package Log
+
+/*
+ * Leveled, structured log of the kernel. A message has a level, a text and
+ * fields (key value pairs), the messages of an LP carry its identifier,
+ * simulated time and GVT. The lines are written as key=value on the
+ * standard error, so that they are not mixed with the output of the model,
+ * or in a file per LP under Const.LOGDIR. The debug messages exist only if
+ * the constant DEBUG is true: the compiler removes the code guarded by
+ * "if Log.DEBUG", arguments included
+ */
+
+import(
+    "./Const"
+    "./DT"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+    "strconv"
+    "sync"
+    "time"
+)
+
+const DEBUG = false
+
+/* levels */
+const(
+    LDEBUG = iota
+    LINFO = iota
+    LWARN = iota
+    LERROR = iota
+)
+
+var names = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+var(
+    Level int = LINFO		// the messages below it are dropped
+    out io.Writer = os.Stderr
+    files []*os.File		// per LP, nil if the LP messages go to out
+    lock sync.Mutex
+    start int64 = time.Nanoseconds()
+)
+
+/* a source of messages with its fields */
+type Logger struct {
+    lp int			// -1 if not of an LP
+    fields string		// formatted
+}
+
+var kernel = &Logger{-1, ""}
+
+
+/*
+ * sets the level by name: debug, info, warn or error. The debug level is
+ * rejected if the debug messages are not compiled in, see DEBUG
+ */
+func SetLevel(name string) os.Error {
+    for i:=0;i<len(names);i++ {
+        if strings.ToUpper(name) == names[i] {
+            if i == LDEBUG && !DEBUG {
+                return os.NewError("the debug messages are not compiled in, see Log.DEBUG")
+            }
+            Level = i
+            return nil
+        }
+    }
+    return os.NewError("unknown log level "+name)
+}
+
+
+/* the messages of each LP go to Const.LOGDIR/lp.<LP>.log */
+func Setup(lpn int) os.Error {
+    var err os.Error
+
+    files = make([]*os.File, lpn)
+    for i:=0;i<lpn;i++ {
+        files[i],err = os.Open(fmt.Sprintf("%slp.%d.log", Const.LOGDIR, i), os.O_WRONLY|os.O_CREAT|os.O_TRUNC, Const.PERM)
+        if err != nil {
+            Close()
+            return err
+        }
+    }
+    return nil
+}
+
+
+func Close() os.Error {
+    var err os.Error
+
+    lock.Lock()
+    defer lock.Unlock()
+    for i:=0;i<len(files);i++ {
+        if files[i] == nil {
+            continue
+        }
+        if e := files[i].Close(); e != nil && err == nil {
+            err = e
+        }
+    }
+    files = nil
+    return err
+}
+
+
+/* the logger of LP lp */
+func For(lp DT.Pid) *Logger {
+    return &Logger{int(lp), " lp="+strconv.Itoa(int(lp))}
+}
+
+
+/* the logger of LP lp at simulated time t, with the GVT it knows */
+func LP(lp DT.Pid, t DT.Time, gvt DT.Time) *Logger {
+    return For(lp).With("time", t, "gvt", gvt)
+}
+
+
+/* a logger with more fields, kv are key value pairs */
+func (l *Logger) With(kv ...interface{}) *Logger {
+    return &Logger{l.lp, l.fields+format(kv)}
+}
+
+
+func (l *Logger) Debug(msg string, kv ...interface{}) {
+    if DEBUG {
+        l.write(LDEBUG, msg, kv)
+    }
+}
+
+func (l *Logger) Info(msg string, kv ...interface{}) {
+    l.write(LINFO, msg, kv)
+}
+
+func (l *Logger) Warn(msg string, kv ...interface{}) {
+    l.write(LWARN, msg, kv)
+}
+
+func (l *Logger) Error(msg string, kv ...interface{}) {
+    l.write(LERROR, msg, kv)
+}
+
+
+/* messages not of an LP */
+func Debug(msg string, kv ...interface{}) {
+    if DEBUG {
+        kernel.write(LDEBUG, msg, kv)
+    }
+}
+
+func Info(msg string, kv ...interface{}) {
+    kernel.write(LINFO, msg, kv)
+}
+
+func Warn(msg string, kv ...interface{}) {
+    kernel.write(LWARN, msg, kv)
+}
+
+func Error(msg string, kv ...interface{}) {
+    kernel.write(LERROR, msg, kv)
+}
+
+
+func (l *Logger) write(level int, msg string, kv []interface{}) {
+    if level < Level {
+        return
+    }
+    line := fmt.Sprintf("t=%.6f level=%s%s msg=%s%s\n", float64(time.Nanoseconds()-start)/1e9,
+        names[level], l.fields, value(msg), format(kv))
+
+    lock.Lock()
+    w := out
+    if l.lp >= 0 && l.lp < len(files) {
+        w = files[l.lp]
+    }
+    io.WriteString(w, line)
+    lock.Unlock()
+}
+
+
+/* " key=value" for each pair, a key without value gets "!MISSING" */
+func format(kv []interface{}) string {
+    s := ""
+    for i:=0;i<len(kv);i+=2 {
+        s += " "+fmt.Sprint(kv[i])+"="
+        if i+1 < len(kv) {
+            s += value(fmt.Sprint(kv[i+1]))
+        } else {
+            s += "!MISSING"
+        }
+    }
+    return s
+}
+
+
+/* quoted if it contains spaces, quotes or = */
+func value(s string) string {
+    if s == "" {
+        return "\"\""
+    }
+    for _,c := range s {
+        if c == ' ' || c == '\t' || c == '\n' || c == '"' || c == '=' {
+            return strconv.Quote(s)
+        }
+    }
+    return s
+}
@@ -0,0 +1,118 @@
+/*
+	GO-WARP: a Time Warp simulator written in Go
+	http://pads.cs.unibo.it
+  
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+
+package Metrics
+
+/*
+ * Optional HTTP endpoint that exposes the live counters of a running
+ * simulation in the Prometheus text format. The per-LP values are the
+ * ones published by each LP at its last GVT, see Stats.Publish. The
+ * counters only grow, the rates are left to Prometheus (e.g.
+ * rate(gowarp_events_processed_total[1m]))
+ */
+
+import(
+    "./DT"
+    "./Gvt"
+    "./Shared"
+    "./Stats"
+    "./Communication"
+    "./Log"
+    "fmt"
+    "http"
+    "net"
+    "os"
+)
+
+const PATH = "/metrics"
+
+
+/*
+ * starts serving the metrics on addr (e.g. ":8080"), returns an error if
+ * the address cannot be used. It must be called after Sim.Setup
+ */
+func Start(addr string) os.Error {
+    l,err := net.Listen("tcp", addr)
+    if err != nil {
+        return err
+    }
+
+    Stats.Live = true
+    http.HandleFunc(PATH, serve)
+
+    go func() {
+        err := http.Serve(l, nil)
+        if err != nil {
+            Log.Error("metrics endpoint stopped", "err", err)
+        }
+    }()
+    return nil
+}
+
+
+func serve(c *http.Conn, req *http.Request) {
+    c.SetHeader("Content-Type", "text/plain; version=0.0.4")
+    write(c)
+}
+
+
+func header(w *http.Conn, name string, kind string, help string) {
+    fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+
+func write(w *http.Conn) {
+    lps := Stats.Snapshot()
+
+    header(w, "gowarp_gvt", "gauge", "Last computed Global Virtual Time.")
+    fmt.Fprintf(w, "gowarp_gvt %d\n", Gvt.Last())
+
+    header(w, "gowarp_gvt_evaluations_total", "counter", "Completed GVT evaluations.")
+    fmt.Fprintf(w, "gowarp_gvt_evaluations_total %d\n", Gvt.Rounds())
+
+    header(w, "gowarp_lp_simtime", "gauge", "Local simulated time of each LP at its last GVT.")
+    for i:=0;i<Shared.Lpnum;i++ {
+        fmt.Fprintf(w, "gowarp_lp_simtime{lp=\"%d\"} %d\n", i, lps[i].SimTime)
+    }
+
+    header(w, "gowarp_events_processed_total", "counter", "Executed events, re-executions included.")
+    for i:=0;i<Shared.Lpnum;i++ {
+        fmt.Fprintf(w, "gowarp_events_processed_total{lp=\"%d\"} %d\n", i, lps[i].Processed)
+    }
+
+    header(w, "gowarp_events_committed_total", "counter", "Committed events.")
+    for i:=0;i<Shared.Lpnum;i++ {
+        fmt.Fprintf(w, "gowarp_events_committed_total{lp=\"%d\"} %d\n", i, lps[i].Committed)
+    }
+
+    header(w, "gowarp_rollbacks_total", "counter", "Rollbacks.")
+    for i:=0;i<Shared.Lpnum;i++ {
+        fmt.Fprintf(w, "gowarp_rollbacks_total{lp=\"%d\"} %d\n", i, lps[i].Rollbacks)
+    }
+
+    header(w, "gowarp_pending_events", "gauge", "Events in the pending event set of each LP.")
+    for i:=0;i<Shared.Lpnum;i++ {
+        fmt.Fprintf(w, "gowarp_pending_events{lp=\"%d\"} %d\n", i, lps[i].Pending)
+    }
+
+    header(w, "gowarp_channel_queue_depth", "gauge", "Messages waiting in each Communication channel.")
+    for i:=0;i<Shared.Lpnum;i++ {
+        fmt.Fprintf(w, "gowarp_channel_queue_depth{channel=\"%d\"} %d\n", i, Communication.QueueLen(DT.Pid(i)))
+    }
+
+    header(w, "gowarp_history_bytes", "gauge", "Approximate memory held in the history lists of each LP.")
+    for i:=0;i<Shared.Lpnum;i++ {
+        fmt.Fprintf(w, "gowarp_history_bytes{lp=\"%d\"} %d\n", i, lps[i].HistoryBytes)
+    }
+}
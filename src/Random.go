@@ -15,19 +15,44 @@ package Random
 
 import "fmt"
 import "math"
+import "os"
+import "strconv"
+import "./Log"
 
 /*
- * Linear Congruential Generator LGC 16807
+ * Two generators are available, selected by Generator:
+ *  - LCG: Linear Congruential Generator LGC 16807, period 2^31-2
+ *  - MRG32K3A: L'Ecuyer combined multiple recursive generator, period 2^191,
+ *    with streams of 2^127 numbers divided in substreams of 2^76 numbers
+ *
+ * The RNG is a plain value, so that it can be copied by state saving.
  */
 
-type RNG struct{ Seed, Prev int64 }
+type RNG struct{
+    Seed, Prev int64		// LCG state
+    Kind int
+    S [6]int64			// MRG32k3a state
+}
+
+/* generators */
+const(
+    LCG = iota
+    MRG32K3A = iota
+)
+
+var(
+    Generator int = LCG		// used by the new RNGs
+    Replication int64 = 0	// selects the substream of every stream
+)
 
 
 const (
     module int64 = 1<<31 -1	// RNG module
     coeff int64 = 16807		// RNG coefficient
-    LAMBDA = 5.0		// exponential distribution parameter
-    SD = 1.0			// normal distribution parameter
+    STREAMLEN int64 = 1<<20	// LCG: distance between two streams in the sequence
+    MAXSTREAMS int64 = (module-1)/STREAMLEN	// LCG: streams that do not overlap in its period
+    LAMBDA = 5.0		// exponential distribution parameter, used by RandIntExponential
+    SD = 1.0			// normal distribution parameter, used by RandNormal
 )
 
 
@@ -35,19 +60,117 @@ func RandInit(seed int64) *RNG{
     var rngptr *RNG = new(RNG)
 
     if seed == 0 {
-        fmt.Println("GO-WARP, ERROR: NON NULL SEED EXPECTED!")
+        Log.Error("non null seed expected")
     }
 
-    *rngptr = RNG{seed, seed}
+    rngptr.Seed = seed
+    rngptr.Prev = seed
+    rngptr.Kind = Generator
+    if Generator == MRG32K3A {
+        mrgSeed(rngptr, seed)
+    }
     return rngptr
 }
 
 
+/* coeff^e mod module */
+func powmod(e int64) int64 {
+    var r int64 = 1
+    var a int64 = coeff
+
+    for ; e>0; e>>=1 {
+        if e&1 == 1 {
+            r = (r*a) % module
+        }
+        a = (a*a) % module
+    }
+    return r
+}
+
+
+/*
+ * returns the n-th stream derived from seed, positioned at the beginning
+ * of the substream of the current Replication
+ */
+func RandStream(seed int64, n int64) *RNG {
+    return RandSubstream(seed, n, Replication)
+}
+
+
+/*
+ * checks that the streams [0, n) of the current Replication do not overlap:
+ * the LCG has MAXSTREAMS of them and no substreams, the larger models and
+ * the replications must use MRG32K3A
+ */
+func CheckStreams(n int64) os.Error {
+    if Generator == LCG && n > MAXSTREAMS {
+        return os.NewError(fmt.Sprint(n, " random number streams needed, the LCG has ", MAXSTREAMS, ": use mrg32k3a"))
+    }
+    if Generator == LCG && Replication != 0 {
+        return os.NewError("the LCG has no independent replications: use mrg32k3a")
+    }
+    return nil
+}
+
+
+/*
+ * selects the generator by name (lcg or mrg32k3a, as in the -rng option of
+ * the models) and checks that it has the n streams the model needs
+ */
+func Setup(name string, n int64) os.Error {
+    switch name {
+        case "lcg":
+        Generator = LCG
+        case "mrg32k3a":
+        Generator = MRG32K3A
+        default:
+        return os.NewError("unknown random number generator "+name)
+    }
+    return CheckStreams(n)
+}
+
+
+/*
+ * returns substream sub of the n-th stream derived from seed. With the LCG
+ * the stream starts n*STREAMLEN draws after seed, streams do not overlap as
+ * long as each one draws less than STREAMLEN numbers and n < MAXSTREAMS.
+ * A stream of 2^20 numbers is too short to be split into uncorrelated
+ * substreams, so the LCG only has substream 0 (see CheckStreams)
+ */
+func RandSubstream(seed int64, n int64, sub int64) *RNG {
+    if Generator == MRG32K3A {
+        rng := RandInit(seed)
+        mrgJump(rng, &a1p127, &a2p127, n)
+        mrgJump(rng, &a1p76, &a2p76, sub)
+        return rng
+    }
+
+    if n >= MAXSTREAMS {
+        Log.Error("the random number stream overlaps another one", "stream", n, "streams", MAXSTREAMS)
+    }
+    if sub != 0 {
+        Log.Error("the LCG has no substreams", "substream", sub)
+    }
+    s := seed % module
+    if s <= 0 {
+        Log.Error("non null seed expected")
+        s += module-1
+    }
+    s = (s * powmod((n*STREAMLEN) % (module-1))) % module
+    rng := RandInit(s)
+    return rng
+}
+
+
 /* This function randomically generates a float number in the range 0..1 */
 func (rng *RNG) RandFloat() float64 {
     var n int64
     var fl float64
 
+    if rng.Kind == MRG32K3A {
+        return rng.mrgNext()
+    }
+
     n = (coeff*(rng.Prev)) % module
     rng.Prev = n
     
@@ -67,6 +190,555 @@ func (rng *RNG) RandIntUniform(min int32, max int32) int32 {
 }
 
 
+/* standard deviation SD, mean 0 */
+func (rng *RNG) RandNormal() float64 {
+    return rng.Normal(0, SD)
+}
+
+
 func (rng *RNG) RandIntExponential() int32 {
     return int32( -LAMBDA * math.Log(rng.RandFloat()) + 1 )
 }
+
+
+/*
+ * Random variates. They use only the generator they are called on, so
+ * drawing from an LP stream (Local.LocalData.Rng) is rollback-safe
+ */
+
+/* uniform in [a, b) */
+func (rng *RNG) Uniform(a float64, b float64) float64 {
+    return a + (b-a)*rng.RandFloat()
+}
+
+
+func (rng *RNG) Exponential(mean float64) float64 {
+    return -mean * math.Log(rng.RandFloat())
+}
+
+
+/* Box-Muller, no value is cached between the calls */
+func (rng *RNG) Normal(mu float64, sigma float64) float64 {
+    u1 := rng.RandFloat()
+    u2 := rng.RandFloat()
+    return mu + sigma*math.Sqrt(-2*math.Log(u1))*math.Cos(2*math.Pi*u2)
+}
+
+
+/* mu and sigma are the parameters of the underlying normal distribution */
+func (rng *RNG) LogNormal(mu float64, sigma float64) float64 {
+    return math.Exp(rng.Normal(mu, sigma))
+}
+
+
+/* Marsaglia and Tsang method, shape > 0 */
+func (rng *RNG) Gamma(shape float64, scale float64) float64 {
+    if shape < 1 {
+        return rng.Gamma(shape+1, scale) * math.Pow(rng.RandFloat(), 1/shape)
+    }
+
+    d := shape - 1.0/3
+    c := 1/math.Sqrt(9*d)
+    for {
+        x := rng.Normal(0, 1)
+        v := 1 + c*x
+        if v <= 0 {
+            continue
+        }
+        v = v*v*v
+        u := rng.RandFloat()
+        if math.Log(u) < 0.5*x*x + d - d*v + d*math.Log(v) {
+            return d*v*scale
+        }
+    }
+    return 0
+}
+
+
+/* sum of k exponentials with the given mean */
+func (rng *RNG) Erlang(k int, mean float64) float64 {
+    var p float64 = 1
+
+    for i:=0;i<k;i++ {
+        p *= rng.RandFloat()
+    }
+    return -mean * math.Log(p)
+}
+
+
+func (rng *RNG) Weibull(shape float64, scale float64) float64 {
+    return scale * math.Pow(-math.Log(rng.RandFloat()), 1/shape)
+}
+
+
+/* values >= scale */
+func (rng *RNG) Pareto(shape float64, scale float64) float64 {
+    return scale / math.Pow(rng.RandFloat(), 1/shape)
+}
+
+
+/* a <= mode <= b */
+func (rng *RNG) Triangular(a float64, mode float64, b float64) float64 {
+    u := rng.RandFloat()
+    f := (mode-a)/(b-a)
+
+    if u < f {
+        return a + math.Sqrt(u*(b-a)*(mode-a))
+    }
+    return b - math.Sqrt((1-u)*(b-a)*(b-mode))
+}
+
+
+/* logarithm of the gamma function, Lanczos approximation */
+func gammaln(x float64) float64 {
+    cof := [6]float64{76.18009172947146, -86.50532032941677, 24.01409824083091,
+        -1.231739572450155, 0.1208650973866179e-2, -0.5395239384953e-5}
+
+    y := x
+    tmp := x + 5.5
+    tmp -= (x+0.5)*math.Log(tmp)
+    ser := 1.000000000190015
+    for j:=0;j<6;j++ {
+        y++
+        ser += cof[j]/y
+    }
+    return -tmp + math.Log(2.5066282746310005*ser/x)
+}
+
+
+/* multiplication method for small means, rejection method otherwise */
+func (rng *RNG) Poisson(mean float64) int {
+    if mean < 12 {
+        g := math.Exp(-mean)
+        t := 1.0
+        n := -1
+        for t > g {
+            t *= rng.RandFloat()
+            n++
+        }
+        return n
+    }
+
+    sq := math.Sqrt(2*mean)
+    alxm := math.Log(mean)
+    g := mean*alxm - gammaln(mean+1)
+    for {
+        var y, em float64
+        for {
+            y = math.Tan(math.Pi*rng.RandFloat())
+            em = sq*y + mean
+            if em >= 0 {
+                break
+            }
+        }
+        em = math.Floor(em)
+        t := 0.9*(1+y*y)*math.Exp(em*alxm - gammaln(em+1) - g)
+        if rng.RandFloat() <= t {
+            return int(em)
+        }
+    }
+    return 0
+}
+
+
+/* successes in n trials with probability p */
+func (rng *RNG) Binomial(n int, p float64) int {
+    var k int = 0
+
+    pp := p
+    if p > 0.5 {
+        pp = 1-p
+    }
+    mean := float64(n)*pp
+
+    if n < 25 {
+        for i:=0;i<n;i++ {
+            if rng.RandFloat() < pp {
+                k++
+            }
+        }
+    } else if mean < 1 {
+        g := math.Exp(-mean)
+        t := 1.0
+        for k=0;k<=n;k++ {
+            t *= rng.RandFloat()
+            if t < g {
+                break
+            }
+        }
+        if k > n {
+            k = n
+        }
+    } else {
+        en := float64(n)
+        g := gammaln(en+1)
+        plog := math.Log(pp)
+        pclog := math.Log(1-pp)
+        sq := math.Sqrt(2*mean*(1-pp))
+        for {
+            var y, em float64
+            for {
+                y = math.Tan(math.Pi*rng.RandFloat())
+                em = sq*y + mean
+                if em >= 0 && em < en+1 {
+                    break
+                }
+            }
+            em = math.Floor(em)
+            t := 1.2*sq*(1+y*y)*math.Exp(g - gammaln(em+1) - gammaln(en-em+1) + em*plog + (en-em)*pclog)
+            if rng.RandFloat() <= t {
+                k = int(em)
+                break
+            }
+        }
+    }
+
+    if pp != p {
+        k = n-k
+    }
+    return k
+}
+
+
+/* failures before the first success, 0 < p <= 1 (see ParseDist), 0 otherwise */
+func (rng *RNG) Geometric(p float64) int {
+    if p <= 0 || p > 1 {
+        Log.Error("geometric distribution with p outside (0, 1]", "p", p)
+        return 0
+    }
+    if p == 1 {
+        return 0
+    }
+    return int(math.Floor(math.Log(rng.RandFloat())/math.Log(1-p)))
+}
+
+
+/*
+ * table of a discrete (empirical) distribution: Values[i] is drawn with
+ * probability proportional to the weight given to NewTable
+ */
+type Table struct {
+    Values []float64
+    cdf []float64
+}
+
+
+/* a value and a non negative weight per entry, the weights must not sum to 0 */
+func NewTable(values []float64, weights []float64) (*Table, os.Error) {
+    var sum float64 = 0
+
+    if len(values) != len(weights) {
+        return nil, os.NewError(fmt.Sprint(len(values), " values and ", len(weights), " weights"))
+    }
+    t := new(Table)
+    t.Values = values
+    t.cdf = make([]float64, len(weights))
+    for i:=0;i<len(weights);i++ {
+        if weights[i] < 0 || math.IsNaN(weights[i]) || math.IsInf(weights[i], 0) {
+            return nil, os.NewError(fmt.Sprint("bad weight ", weights[i], " of entry ", i))
+        }
+        sum += weights[i]
+        t.cdf[i] = sum
+    }
+    if sum <= 0 {
+        return nil, os.NewError("the weights sum to 0")
+    }
+    for i:=0;i<len(weights);i++ {
+        t.cdf[i] /= sum
+    }
+    return t, nil
+}
+
+
+/* Zipf distribution over the ranks 1..n with exponent s */
+func NewZipf(n int, s float64) (*Table, os.Error) {
+    if n < 1 {
+        return nil, os.NewError(fmt.Sprint("Zipf distribution over ", n, " ranks"))
+    }
+    values := make([]float64, n)
+    weights := make([]float64, n)
+
+    for i:=0;i<n;i++ {
+        values[i] = float64(i+1)
+        weights[i] = 1/math.Pow(float64(i+1), s)
+    }
+    return NewTable(values, weights)
+}
+
+
+/* index of the drawn entry of the table, by binary search */
+func (rng *RNG) Discrete(t *Table) int {
+    u := rng.RandFloat()
+    lo := 0
+    hi := len(t.cdf)-1
+
+    for lo < hi {
+        mid := (lo+hi)/2
+        if t.cdf[mid] < u {
+            lo = mid+1
+        } else {
+            hi = mid
+        }
+    }
+    return lo
+}
+
+
+func (rng *RNG) Empirical(t *Table) float64 {
+    return t.Values[rng.Discrete(t)]
+}
+
+
+/* rank in 1..n of a table built by NewZipf */
+func (rng *RNG) Zipf(t *Table) int {
+    return int(t.Values[rng.Discrete(t)])
+}
+
+
+/*
+ * a distribution given by name and parameters, as written in the model
+ * configuration files (e.g. "exponential 5" or "uniform 1 10")
+ */
+type Dist struct {
+    Kind string
+    P []float64
+}
+
+var distParams = map[string]int{"constant": 1, "uniform": 2, "exponential": 1, "normal": 2, "lognormal": 2,
+    "gamma": 2, "erlang": 2, "weibull": 2, "pareto": 2, "triangular": 3,
+    "poisson": 1, "binomial": 2, "geometric": 1}
+
+
+/*
+ * f holds the name followed by the parameters. The parameters of the
+ * discrete distributions are checked here, they are not checked by Draw
+ */
+func ParseDist(f []string) (*Dist, os.Error) {
+    if len(f) == 0 {
+        return nil, os.NewError("missing distribution")
+    }
+    np,ok := distParams[f[0]]
+    if !ok {
+        return nil, os.NewError("unknown distribution "+f[0])
+    }
+    if len(f)-1 != np {
+        return nil, os.NewError(fmt.Sprintf("%s wants %d parameters", f[0], np))
+    }
+
+    d := &Dist{f[0], make([]float64, np)}
+    for i:=0;i<np;i++ {
+        x,err := strconv.Atof64(f[i+1])
+        if err != nil {
+            return nil, os.NewError("bad value "+f[i+1])
+        }
+        d.P[i] = x
+    }
+
+    switch d.Kind {
+        case "poisson":
+        if !(d.P[0] >= 0) {
+            return nil, os.NewError("poisson wants a non negative mean")
+        }
+        case "binomial":
+        if !(d.P[0] >= 0) || d.P[0] != math.Floor(d.P[0]) || !(d.P[1] >= 0 && d.P[1] <= 1) {
+            return nil, os.NewError("binomial wants a whole number of trials and a probability in [0, 1]")
+        }
+        case "geometric":
+        if !(d.P[0] > 0 && d.P[0] <= 1) {
+            return nil, os.NewError("geometric wants a probability in (0, 1]")
+        }
+    }
+    return d, nil
+}
+
+
+/* negative values are returned as 0 */
+func (d *Dist) Draw(rng *RNG) float64 {
+    var x float64
+
+    switch d.Kind {
+        case "constant":
+        x = d.P[0]
+        case "uniform":
+        x = rng.Uniform(d.P[0], d.P[1])
+        case "exponential":
+        x = rng.Exponential(d.P[0])
+        case "normal":
+        x = rng.Normal(d.P[0], d.P[1])
+        case "lognormal":
+        x = rng.LogNormal(d.P[0], d.P[1])
+        case "gamma":
+        x = rng.Gamma(d.P[0], d.P[1])
+        case "erlang":
+        x = rng.Erlang(int(d.P[0]), d.P[1])
+        case "weibull":
+        x = rng.Weibull(d.P[0], d.P[1])
+        case "pareto":
+        x = rng.Pareto(d.P[0], d.P[1])
+        case "triangular":
+        x = rng.Triangular(d.P[0], d.P[1], d.P[2])
+        case "poisson":
+        x = float64(rng.Poisson(d.P[0]))
+        case "binomial":
+        x = float64(rng.Binomial(int(d.P[0]), d.P[1]))
+        case "geometric":
+        x = float64(rng.Geometric(d.P[0]))
+    }
+    if x < 0 {
+        return 0
+    }
+    return x
+}
+
+
+/* expected value, the truncation of the negative values is not considered */
+func (d *Dist) Mean() float64 {
+    switch d.Kind {
+        case "constant", "exponential", "normal", "poisson":
+        return d.P[0]
+        case "uniform":
+        return (d.P[0]+d.P[1])/2
+        case "lognormal":
+        return math.Exp(d.P[0] + d.P[1]*d.P[1]/2)
+        case "gamma", "erlang":
+        return d.P[0]*d.P[1]
+        case "weibull":
+        return d.P[1]*math.Exp(gammaln(1+1/d.P[0]))
+        case "pareto":
+        if d.P[0] <= 1 {
+            return math.Inf(1)
+        }
+        return d.P[0]*d.P[1]/(d.P[0]-1)
+        case "triangular":
+        return (d.P[0]+d.P[1]+d.P[2])/3
+        case "binomial":
+        return d.P[0]*d.P[1]
+        case "geometric":
+        return (1-d.P[0])/d.P[0]
+    }
+    return 0
+}
+
+
+func (d *Dist) String() string {
+    return fmt.Sprint(d.Kind, d.P)
+}
+
+
+/*
+ * MRG32k3a, see P. L'Ecuyer, R. Simard, E. J. Chen, W. D. Kelton, "An object-
+ * oriented random-number package with many long streams and substreams",
+ * Operations Research 50(6), 2002
+ */
+
+const(
+    m1 int64 = 4294967087
+    m2 int64 = 4294944443
+    a12 int64 = 1403580
+    a13n int64 = 810728
+    a21 int64 = 527612
+    a23n int64 = 1370589
+    norm = 2.328306549295727688e-10	// 1/(m1+1)
+)
+
+type matrix [3][3]int64
+
+/* jump matrices: 2^76 steps (substreams) and 2^127 steps (streams) */
+var a1p76, a2p76, a1p127, a2p127 matrix
+
+
+func init() {
+    a1 := matrix{{0, 1, 0}, {0, 0, 1}, {m1-a13n, a12, 0}}
+    a2 := matrix{{0, 1, 0}, {0, 0, 1}, {m2-a23n, 0, a21}}
+
+    for i:=0;i<127;i++ {
+        if i == 76 {
+            a1p76 = a1
+            a2p76 = a2
+        }
+        a1 = matmul(&a1, &a1, m1)
+        a2 = matmul(&a2, &a2, m2)
+    }
+    a1p127 = a1
+    a2p127 = a2
+}
+
+
+/* a*b mod m, the entries are lower than 2^32 so the products fit in an uint64 */
+func matmul(a *matrix, b *matrix, m int64) matrix {
+    var c matrix
+
+    for i:=0;i<3;i++ {
+        for j:=0;j<3;j++ {
+            var s uint64 = 0
+            for k:=0;k<3;k++ {
+                s += (uint64(a[i][k])*uint64(b[k][j])) % uint64(m)
+            }
+            c[i][j] = int64(s % uint64(m))
+        }
+    }
+    return c
+}
+
+
+/* v = a*v mod m */
+func matvec(a *matrix, v []int64, m int64) {
+    var r [3]int64
+
+    for i:=0;i<3;i++ {
+        var s uint64 = 0
+        for k:=0;k<3;k++ {
+            s += (uint64(a[i][k])*uint64(v[k])) % uint64(m)
+        }
+        r[i] = int64(s % uint64(m))
+    }
+    for i:=0;i<3;i++ {
+        v[i] = r[i]
+    }
+}
+
+
+func mrgSeed(rng *RNG, seed int64) {
+    s1 := seed % m1
+    s2 := seed % m2
+    if s1 <= 0 { s1 = 12345 }
+    if s2 <= 0 { s2 = 12345 }
+    for i:=0;i<3;i++ {
+        rng.S[i] = s1
+        rng.S[i+3] = s2
+    }
+}
+
+
+/* advances the state by n times the jump of (j1, j2) */
+func mrgJump(rng *RNG, j1 *matrix, j2 *matrix, n int64) {
+    p1 := *j1
+    p2 := *j2
+
+    for ; n>0; n>>=1 {
+        if n&1 == 1 {
+            matvec(&p1, rng.S[0:3], m1)
+            matvec(&p2, rng.S[3:6], m2)
+        }
+        p1 = matmul(&p1, &p1, m1)
+        p2 = matmul(&p2, &p2, m2)
+    }
+}
+
+
+func (rng *RNG) mrgNext() float64 {
+    s := &rng.S
+
+    p1 := (a12*s[1] - a13n*s[0]) % m1
+    if p1 < 0 { p1 += m1 }
+    s[0] = s[1]; s[1] = s[2]; s[2] = p1
+
+    p2 := (a21*s[5] - a23n*s[3]) % m2
+    if p2 < 0 { p2 += m2 }
+    s[3] = s[4]; s[4] = s[5]; s[5] = p2
+
+    if p1 > p2 {
+        return float64(p1 - p2)*norm
+    }
+    return float64(p1 - p2 + m1)*norm
+}
@@ -0,0 +1,249 @@
+/*
+	GO-WARP: a Time Warp simulator written in Go
+	http://pads.cs.unibo.it
+
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+
+package Random
+
+import(
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+/*
+ * MRG32k3a reference values, see L'Ecuyer et al. (2002) and their RngStreams
+ * package: the default seed is 12345 for the six components
+ */
+var mrgFirst = []float64{0.1270111220, 0.3185275654, 0.3091860156, 0.8258468629, 0.2216299158}
+
+var mrgStreams = [][6]int64{
+    {12345, 12345, 12345, 12345, 12345, 12345},
+    {3692455944, 1366884236, 2968912127, 335948734, 4161675175, 475798818},
+    {1015873554, 1310354410, 2249465273, 994084013, 2912484720, 3876682925},
+}
+
+/* the state of the default stream after 2^76 steps, its substream 1 */
+var mrgSubstream1 = [6]int64{870504860, 2641697727, 884013853, 339352413, 2374306706, 3651603887}
+
+
+func sameState(a [6]int64, b [6]int64) bool {
+    for i:=0;i<6;i++ {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+
+func withGenerator(g int, f func()) {
+    prev := Generator
+    Generator = g
+    defer func() { Generator = prev }()
+    f()
+}
+
+
+func TestMRGFirst(t *testing.T) {
+    withGenerator(MRG32K3A, func() {
+        rng := RandStream(12345, 0)
+        for i:=0;i<len(mrgFirst);i++ {
+            if u := rng.RandFloat(); math.Fabs(u-mrgFirst[i]) > 1e-10 {
+                t.Errorf("draw %d: %.10f, %.10f expected", i, u, mrgFirst[i])
+            }
+        }
+    })
+}
+
+
+func TestMRGStreams(t *testing.T) {
+    withGenerator(MRG32K3A, func() {
+        for n:=0;n<len(mrgStreams);n++ {
+            if s := RandSubstream(12345, int64(n), 0).S; !sameState(s, mrgStreams[n]) {
+                t.Errorf("stream %d: %v, %v expected", n, s, mrgStreams[n])
+            }
+        }
+        if s := RandSubstream(12345, 0, 1).S; !sameState(s, mrgSubstream1) {
+            t.Errorf("substream 1: %v, %v expected", s, mrgSubstream1)
+        }
+    })
+}
+
+
+/* 16807 is a primitive root of module: the period is module-1 */
+func TestLCGPeriod(t *testing.T) {
+    factors := []int64{2, 3, 7, 11, 31, 151, 331}	// module-1 = 2*3^2*7*11*31*151*331
+
+    if powmod(module-1) != 1 {
+        t.Fatalf("%d^(module-1) is not 1", coeff)
+    }
+    for _,q := range factors {
+        if powmod((module-1)/q) == 1 {
+            t.Errorf("the period divides (module-1)/%d", q)
+        }
+    }
+}
+
+
+/*
+ * the LCG streams are consecutive segments of STREAMLEN numbers of its
+ * period: stream n begins where stream n-1 ends, and the last one ends
+ * before the sequence comes back to stream 0
+ */
+func TestLCGStreams(t *testing.T) {
+    if MAXSTREAMS*STREAMLEN > module-1 {
+        t.Fatalf("%d streams of %d numbers exceed the period %d", MAXSTREAMS, STREAMLEN, module-1)
+    }
+    if err := CheckStreams(MAXSTREAMS); err != nil {
+        t.Errorf("CheckStreams(MAXSTREAMS): %s", err)
+    }
+    if err := CheckStreams(MAXSTREAMS+1); err == nil {
+        t.Errorf("CheckStreams(MAXSTREAMS+1) accepted")
+    }
+
+    withGenerator(LCG, func() {
+        for _,n := range []int64{1, 2, MAXSTREAMS-1} {
+            rng := RandStream(7, n-1)
+            for i:=int64(0);i<STREAMLEN;i++ {
+                rng.RandFloat()
+            }
+            if next := RandStream(7, n); rng.Prev != next.Prev {
+                t.Errorf("stream %d does not begin where stream %d ends", n, n-1)
+            }
+        }
+    })
+}
+
+
+/* sample mean and variance of n draws */
+func moments(n int, draw func() float64) (mean float64, variance float64) {
+    var sum, sum2 float64
+    for i:=0;i<n;i++ {
+        x := draw()
+        sum += x
+        sum2 += x*x
+    }
+    mean = sum/float64(n)
+    variance = sum2/float64(n) - mean*mean
+    return
+}
+
+
+/*
+ * the sample mean must be within 5 standard errors of the expected one,
+ * the sample variance within 5% of the expected one
+ */
+func checkMoments(t *testing.T, name string, mean float64, variance float64, draw func() float64) {
+    const N = 100000
+
+    m, v := moments(N, draw)
+    if math.Fabs(m-mean) > 5*math.Sqrt(variance/N) {
+        t.Errorf("%s: mean %f, %f expected", name, m, mean)
+    }
+    if math.Fabs(v-variance) > 0.05*variance {
+        t.Errorf("%s: variance %f, %f expected", name, v, variance)
+    }
+}
+
+
+func TestPoisson(t *testing.T) {
+    rng := RandStream(11, 0)
+    for _,mean := range []float64{0.5, 4, 30, 200} {
+        checkMoments(t, "poisson " + strconv.Ftoa64(mean, 'g', -1), mean, mean,
+            func() float64 { return float64(rng.Poisson(mean)) })
+    }
+}
+
+
+/* the three methods: n < 25, mean < 1 and rejection, also with p > 0.5 */
+func TestBinomial(t *testing.T) {
+    cases := []struct { n int; p float64 }{{10, 0.3}, {20, 0.8}, {100, 0.005}, {100, 0.4}, {1000, 0.9}}
+
+    rng := RandStream(13, 0)
+    for _,c := range cases {
+        n, p := c.n, c.p
+        checkMoments(t, fmt.Sprintf("binomial %d %g", n, p), float64(n)*p, float64(n)*p*(1-p),
+            func() float64 { return float64(rng.Binomial(n, p)) })
+    }
+}
+
+
+func TestGeometric(t *testing.T) {
+    rng := RandStream(17, 0)
+    for _,p := range []float64{0.1, 0.5, 0.9} {
+        checkMoments(t, "geometric " + strconv.Ftoa64(p, 'g', -1), (1-p)/p, (1-p)/(p*p),
+            func() float64 { return float64(rng.Geometric(p)) })
+    }
+    if k := rng.Geometric(1); k != 0 {
+        t.Errorf("geometric 1: %d, 0 expected", k)
+    }
+}
+
+
+func sameParams(a []float64, b []float64) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i:=0;i<len(a);i++ {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+
+func TestParseDist(t *testing.T) {
+    cases := []struct { spec string; mean float64 }{
+        {"constant 3", 3}, {"uniform 1 3", 2}, {"exponential 2.5", 2.5}, {"triangular 0 1 5", 2},
+        {"poisson 7", 7}, {"binomial 20 0.25", 5}, {"geometric 0.2", 4}, {"geometric 1", 0},
+    }
+
+    for _,c := range cases {
+        f := strings.Fields(c.spec)
+        d, err := ParseDist(f)
+        if err != nil {
+            t.Errorf("%q: %s", c.spec, err)
+            continue
+        }
+        if d.Kind != f[0] || len(d.P) != len(f)-1 {
+            t.Errorf("%q: parsed as %s %v", c.spec, d.Kind, d.P)
+        }
+        g := make([]string, len(d.P)+1)
+        g[0] = d.Kind
+        for i,x := range d.P {
+            g[i+1] = strconv.Ftoa64(x, 'g', -1)
+        }
+        if e, err := ParseDist(g); err != nil || e.Kind != d.Kind || !sameParams(e.P, d.P) {
+            t.Errorf("%q: %v does not parse back", c.spec, g)
+        }
+        if math.Fabs(d.Mean()-c.mean) > 1e-12 {
+            t.Errorf("%q: mean %f, %f expected", c.spec, d.Mean(), c.mean)
+        }
+    }
+}
+
+
+func TestParseDistRejects(t *testing.T) {
+    bad := []string{"", "zipf 1.2", "constant", "uniform 1", "normal 0 1 2", "exponential x",
+        "poisson -1", "binomial -1 0.5", "binomial 2.5 0.5", "binomial 10 1.5", "binomial 10 -0.1",
+        "geometric 0", "geometric 1.5", "geometric -0.5"}
+
+    for _,spec := range bad {
+        if d, err := ParseDist(strings.Fields(spec)); err == nil {
+            t.Errorf("%q accepted as %s", spec, d)
+        }
+    }
+}
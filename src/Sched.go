@@ -0,0 +1,203 @@
+/*
+	GO-WARP: a Time Warp simulator written in Go
+	http://pads.cs.unibo.it
+
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+
+package Sched
+
+/*
+ * Decisions of the deterministic mode, where all the LPs run on a single
+ * goroutine: which LP makes the next step and which pending message is
+ * delivered. Every decision is a choice among n options, option 0 is the
+ * default (round robin for the LPs, first sent for the messages). The
+ * choices are drawn from a seeded stream or replayed from a schedule file,
+ * when the file is over the default is taken. Only the decisions with more
+ * than one option are counted, so that a schedule can be shrunk by removing
+ * or zeroing some of them and stays meaningful
+ */
+
+import(
+    "./Random"
+    "fmt"
+    "os"
+    "bufio"
+    "strings"
+    "strconv"
+)
+
+var(
+    Enabled bool = false
+    MaxSteps int64 = 0		// 0 for no limit
+    rng *Random.RNG		// nil when replaying
+    replay []int
+    pos int
+    steps int64
+    record *os.File		// nil if the decisions are not recorded
+)
+
+
+/* disables the deterministic mode and drops the decisions and the steps of the previous run */
+func Reset() {
+    Close()
+    Enabled = false
+    MaxSteps = 0
+    rng = nil
+    replay = nil
+    pos = 0
+    steps = 0
+}
+
+
+/*
+ * parses the comma separated parameters of the deterministic mode and
+ * enables it: seed=n draws the decisions from stream n, replay=file takes
+ * them from a schedule file, record=file writes the taken ones and steps=n
+ * limits the number of steps (to catch livelocks)
+ */
+func Setup(spec string) os.Error {
+    var err os.Error
+    var seed int64 = 1
+    var replayfile, recordfile string
+
+    Reset()
+    for _,item := range strings.Split(spec, ",", -1) {
+        kv := strings.Split(strings.TrimSpace(item), "=", 2)
+        if len(kv) != 2 {
+            return os.NewError("bad scheduler parameter "+item)
+        }
+        switch kv[0] {
+            case "seed":
+            seed,err = strconv.Atoi64(kv[1])
+            case "replay":
+            replayfile = kv[1]
+            case "record":
+            recordfile = kv[1]
+            case "steps":
+            MaxSteps,err = strconv.Atoi64(kv[1])
+            default:
+            return os.NewError("unknown scheduler parameter "+kv[0])
+        }
+        if err != nil {
+            return os.NewError("bad value for "+kv[0]+": "+kv[1])
+        }
+    }
+
+    if replayfile != "" {
+        if replay,err = Load(replayfile); err != nil {
+            return err
+        }
+    } else {
+        rng = Random.RandStream(seed, 0)
+    }
+    if recordfile != "" {
+        record,err = os.Open(recordfile, os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0644)
+        if err != nil {
+            return err
+        }
+    }
+    Enabled = true
+    return nil
+}
+
+
+/* reads a schedule file, one decision per line */
+func Load(filename string) ([]int, os.Error) {
+    file,err := os.Open(filename, os.O_RDONLY, 0)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    rd := bufio.NewReader(file)
+
+    d := make([]int, 0, 1024)
+    for n:=1;;n++ {
+        line,err := rd.ReadString('\n')
+        if err == os.EOF && len(line) == 0 {
+            break
+        }
+        v,err := strconv.Atoi(strings.TrimSpace(line))
+        if err != nil || v < 0 {
+            return nil, os.NewError(fmt.Sprint(filename, " line ", n, ": bad decision"))
+        }
+        if len(d) == cap(d) {
+            d1 := make([]int, len(d), 2*cap(d))
+            copy(d1, d)
+            d = d1
+        }
+        d = d[0:len(d)+1]
+        d[len(d)-1] = v
+    }
+    return d, nil
+}
+
+
+/* writes a schedule file */
+func Save(filename string, d []int) os.Error {
+    file,err := os.Open(filename, os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0644)
+    if err != nil {
+        return err
+    }
+    w := bufio.NewWriter(file)
+    for i:=0;i<len(d);i++ {
+        fmt.Fprintln(w, d[i])
+    }
+    err = w.Flush()
+    file.Close()
+    return err
+}
+
+
+/* takes a decision among n options, it returns a value in [0,n) */
+func Choose(n int) int {
+    var c int = 0
+
+    if n < 2 {
+        return 0
+    }
+    if rng != nil {
+        c = int(rng.RandFloat()*float64(n))
+        if c >= n {
+            c = n-1
+        }
+    } else if pos < len(replay) {
+        c = replay[pos] % n
+        pos++
+    }
+    if record != nil {
+        /* written at once, the run can end with an os.Exit */
+        fmt.Fprintln(record, c)
+    }
+    return c
+}
+
+
+/* counts a step, false when the limit has been reached */
+func Step() bool {
+    steps++
+    return MaxSteps == 0 || steps <= MaxSteps
+}
+
+
+/* number of steps made */
+func Steps() int64 {
+    return steps
+}
+
+
+func Close() os.Error {
+    if record == nil {
+        return nil
+    }
+    err := record.Close()
+    record = nil
+    return err
+}
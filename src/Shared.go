@@ -14,11 +14,11 @@
 package Shared
 
 import(
-    "fmt"
     "time"
     "./DT"
     "./Local"
     "./Const"
+    "./Log"
 )
 
 var(
@@ -27,7 +27,17 @@ var(
     State []int8
     N_rollback []int
     EventManager func(ev *DT.Event, l *Local.LocalData)
+    CommitManager func(ev *DT.Event, st DT.LPstate, l *Local.LocalData)	// nil if the model has no commit hook
+    EntityMap func(entity int) DT.Pid	// LP of an entity, nil if the model does not support the event injection
     EndTime DT.Time
+    Seed int64 = 1		// master seed of the random number streams
+    Rollbacks float64 = 0	// probability of a forced rollback after each event, for testing
+    FaultSeed int64 = 1		// seed of the forced rollbacks
+    RealTime float64 = 0	// simulated time units per wall clock second, 0 if not in real-time mode
+    RealTimeWindow DT.Time	// how far beyond the scaled wall clock the LPs can go
+    CheckpointTime DT.Time	// 0 if no checkpoint has to be taken
+    CheckpointPeriod DT.Time	// 0 for a single checkpoint
+    CheckpointFile string
 
     StartTime int64
 )
@@ -45,6 +55,6 @@ func Setup(lpn int, simt DT.Time, f func(ev *DT.Event, l *Local.LocalData)) {
     }
     EventManager = f
 
-    fmt.Println("SETUP COMPLETED: lpn =",Lpnum,"EndTime =",EndTime)
+    Log.Info("setup completed", "lps", Lpnum, "end", EndTime)
     StartTime = time.Nanoseconds()
 }
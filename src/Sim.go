@@ -21,22 +21,65 @@ package Sim
 import(
     "os"
     "fmt"
+    "flag"
+    "time"
     "./Communication"
     "./DT"
     "./Local"
     "./Const"
     "./Gvt"
     "./Shared"
+    "./Stats"
+    "./Trace"
+    "./CommitLog"
+    "./Checkpoint"
+    "./State"
+    "./Random"
+    "./Check"
+    "./Sched"
+    "./Control"
+    "./Inject"
+    "./Log"
 )
 
 
 const TOOFAR = 25     // limited optimism synchronization: sets how far from the GVT a LP can go
 
+/* real-time mode */
+const RTSLEEP = 1e6	// ns an LP ahead of the scaled wall clock waits before looking again
+const RTGVT = 1e7	// ns between the GVT evaluations, so that the output is released on time
+
+var(
+    forced []*Random.RNG	// streams of the forced rollbacks, nil if disabled
+    rtStart int64		// wall clock time of the simulated time 0, in real-time mode, set before the LPs start
+)
+
 
 func Setup(lpn int, simt DT.Time, f func(ev *DT.Event, l *Local.LocalData)) {
     Communication.AllocateChans(lpn)
+    Sched.Reset()
     Gvt.Setup(lpn)
+    Stats.Setup(lpn)
     Shared.Setup(lpn, simt, f)
+    forced = make([]*Random.RNG, lpn)
+}
+
+
+/*
+ * enables the fault injection of Communication and its forced rollbacks,
+ * after Setup and before Initialize. See Communication.ParseFaults
+ */
+func EnableFaults(spec string) os.Error {
+    f,err := Communication.ParseFaults(spec)
+    if err != nil {
+        return err
+    }
+    Communication.EnableFaults(f)
+    if f.Rollbacks > 0 {
+        Shared.Rollbacks = f.Rollbacks
+        Shared.FaultSeed = f.Seed
+    }
+    return nil
 }
 
 
@@ -49,6 +92,10 @@ func Initialize(i DT.Pid) *Local.LocalData{
 
     data = Local.Initialize(i)
     Shared.State[i] = Const.LPRUNNING
+    if Shared.Rollbacks > 0 {
+        forced[i] = Random.RandStream(Shared.FaultSeed, int64(i))
+    }
+    rtStart = time.Nanoseconds()	// the LPs start after the last one is initialized
 
     return data
 }
@@ -56,30 +103,430 @@ func Initialize(i DT.Pid) *Local.LocalData{
 
 func Simulate(data *Local.LocalData) {
 
-    for {
+    for step(data) {
+    }
+}
 
-        if Shared.State[data.IndexLP] == Const.LPSTOPPED {
-            Shared.State[data.IndexLP] = Const.LPSTOPPED
-            
-            return
+
+/* one iteration of the main loop of the LP, false when the LP has stopped */
+func step(data *Local.LocalData) bool {
+
+    if Shared.State[data.IndexLP] == Const.LPSTOPPED {
+        Shared.State[data.IndexLP] = Const.LPSTOPPED
+
+        /* nothing can be rolled back anymore */
+        commit(Shared.EndTime, data)
+        if Check.DEBUG && Check.Enabled {
+            Check.Stop(data)
         }
+        Stats.Enter(data.IndexLP, Stats.PHKERNEL)
+        if Stats.Live {
+            publish(data)
+        }
+        return false
+    }
+
+    receiveAll(data)
+
+    if data.SimTime>=horizon() {
+        goIdle(data)
+    }
+
+    manageEvent(data)
+
+    if forced[data.IndexLP] != nil && Shared.State[data.IndexLP] != Const.LPSTOPPED {
+        forceRollback(data)
+    }
 
-        receiveAll(data)
+    if Shared.RealTime > 0 && data.Gvt < clock() && Gvt.Age() > RTGVT && Shared.State[data.IndexLP] != Const.LPEVALGVT {
+        ask4NewGvt(data)
+    }
 
-        if data.SimTime>=Shared.EndTime {
-            goIdle(data)
+    if data.GvtFlag && !Gvt.CheckEvaluation() {
+        t := Gvt.GetGvt() 
+        if t != Const.ERR {
+            setGvt(t,data)
         }
+    }
+    return true
+}
+
+
+/*
+ * enables the deterministic mode, after Setup and before Initialize. See
+ * Sched.Setup for the parameters. The LPs must be run by RunScheduled
+ */
+func EnableSched(spec string) os.Error {
+    if Communication.FaultsEnabled() {
+        return os.NewError("the deterministic mode excludes the fault injection")
+    }
+    if Shared.RealTime > 0 {
+        return os.NewError("the deterministic mode excludes the real-time mode")
+    }
+    if Shared.CheckpointTime > 0 {
+        return os.NewError("the deterministic mode excludes the checkpoints")
+    }
+    if Inject.Enabled {
+        return os.NewError("the deterministic mode excludes the event injection")
+    }
+    if Control.Enabled {
+        return os.NewError("the deterministic mode excludes the control requests")
+    }
+    if _,ok := Communication.GetTransport().(*Communication.Channels); !ok {
+        return os.NewError("the deterministic mode excludes the other transports")
+    }
+    if err := Sched.Setup(spec); err != nil {
+        return err
+    }
+    Communication.EnableSched()
+    return nil
+}
+
+
+/*
+ * enables the real-time mode, after Setup and before Initialize: the
+ * simulated time tracks the wall clock at scale time units per second.
+ * The LPs do not process the events beyond the scaled wall clock plus
+ * window (the optimism allowed), nothing after the scaled wall clock is
+ * committed and the GVT is evaluated every RTGVT ns. The run ends when
+ * the scaled wall clock reaches the end time
+ */
+func EnableRealTime(scale float64, window DT.Time) os.Error {
+    if scale <= 0 || window < 0 {
+        return os.NewError("the scale must be positive and the window not negative")
+    }
+    if Sched.Enabled {
+        return os.NewError("the real-time mode excludes the deterministic mode")
+    }
+    Shared.RealTime = scale
+    Shared.RealTimeWindow = window
+    return nil
+}
+
+
+/* the scaled wall clock, in real-time mode. It stops while the simulation is paused */
+func clock() DT.Time {
+    return DT.Time(float64(time.Nanoseconds()-rtStart-Control.PausedTime())*Shared.RealTime/1e9)
+}
+
+
+/*
+ * deterministic mode: all the LPs run on the calling goroutine and at each
+ * step the scheduler chooses which one goes on, among the ones that are
+ * not idle or have a message to receive. Returns when all the LPs have
+ * stopped, or with an error if the step limit is reached or no LP can go on
+ */
+func RunScheduled(datas []*Local.LocalData) os.Error {
+    n := len(datas)
+    done := make([]bool, n)
+    ready := make([]int, n)
+    stopped := 0
+    last := n-1
+
+    defer Sched.Close()
+    for stopped < n {
+        if !Sched.Step() {
+            return os.NewError(fmt.Sprint("step limit reached, ", stopped, " LPs of ", n, " stopped"))
+        }
+
+        /* round robin order from the LP after the last one */
+        k := 0
+        for j:=1;j<=n;j++ {
+            i := (last+j)%n
+            if done[i] || (Shared.State[i] == Const.LPIDLE && Communication.QueueLen(DT.Pid(i)) == 0) {
+                continue
+            }
+            ready[k] = i
+            k++
+        }
+        if k == 0 {
+            return os.NewError(fmt.Sprint("deadlock, all the LPs are idle after ", Sched.Steps(), " steps"))
+        }
+
+        last = ready[Sched.Choose(k)]
+        data := datas[last]
+        if Shared.State[last] == Const.LPIDLE {
+            /* woken up by a message, as after the blocking receive */
+            Shared.State[last] = Const.LPRUNNING
+        }
+        if !step(data) {
+            done[last] = true
+            stopped++
+        }
+    }
+    return nil
+}
+
+
+/* parameters of a run, see Run */
+type Config struct {
+    Lpnum int
+    EndTime DT.Time
+    Seed int64			// master seed of the random number streams
+    Rollbacks float64		// probability of a forced rollback after each event, 0 if disabled
+    Faults string		// fault injection, see EnableFaults, disabled if empty
+    Check bool			// debug mode, see Check
+    Sched string		// deterministic mode, see EnableSched, disabled if empty
+    RealTime float64		// real-time mode, see EnableRealTime, disabled if 0
+    RealTimeWindow DT.Time
+    LogLevel string		// debug (see Log.DEBUG), info, warn or error, info if empty
+    LogFiles bool		// the kernel messages of each LP go to a file, see Log.Setup
+    Timing bool			// measures the wall clock time of the kernel phases, see Stats.Timing
+    Transport Communication.Transport	// the messages among the LPs, buffered channels if nil
+}
+
+
+/*
+ * the command line options of the kernel, shared by the models: a model
+ * registers them with KernelFlags before flag.Parse and gets its Config
+ * from Config
+ */
+type Flags struct {
+    Seed *int64
+    Rollbacks *float64
+    Faults *string
+    Check *bool
+    Sched *string
+    RealTime *float64
+    RealTimeWindow *int
+    Timing *bool
+    Generator *string		// see Random.Setup
+}
+
+func KernelFlags() *Flags {
+    f := new(Flags)
+    f.Seed = flag.Int64("seed", 1, "master seed of the random number streams")
+    f.Rollbacks = flag.Float64("rollbacks", 0, "probability of a forced rollback after each event, for testing")
+    f.Faults = flag.String("faults", "", "fault injection parameters (e.g. delay=0.2,reorder,antifirst), disabled if empty")
+    f.Check = flag.Bool("check", false, "debug mode: checks the Time Warp invariants while running, the kernel must be built with Check.DEBUG")
+    f.Sched = flag.String("sched", "", "deterministic single goroutine mode (e.g. seed=7 or replay=file), disabled if empty")
+    f.RealTime = flag.Float64("realtime", 0, "real-time mode, simulated time units per wall clock second, disabled if 0")
+    f.RealTimeWindow = flag.Int("rtwindow", TOOFAR, "real-time mode, how far beyond the scaled wall clock the LPs can go")
+    f.Timing = flag.Bool("timing", false, "measures the wall clock time of the kernel phases (phase_ns in the statistics)")
+    f.Generator = flag.String("rng", "lcg", "random number generator: lcg or mrg32k3a")
+    return f
+}
+
+/* the configuration of a run of lpnum LPs up to end, with the options of the command line */
+func (f *Flags) Config(lpnum int, end DT.Time) *Config {
+    return &Config{
+        Lpnum: lpnum,
+        EndTime: end,
+        Seed: *f.Seed,
+        Rollbacks: *f.Rollbacks,
+        Faults: *f.Faults,
+        Check: *f.Check,
+        Sched: *f.Sched,
+        RealTime: *f.RealTime,
+        RealTimeWindow: DT.Time(*f.RealTimeWindow),
+        Timing: *f.Timing,
+    }
+}
+
+
+/* the hooks of a model, only Process is required */
+type Model struct {
+    Process func(ev *DT.Event, l *Local.LocalData)
+    Commit func(ev *DT.Event, st DT.LPstate, l *Local.LocalData)	// see Shared.CommitManager
+    Setup func() os.Error			// after the kernel setup, before the LPs are created
+    Init func(data *Local.LocalData) os.Error	// each LP, before any of them starts: model state, initial events
+    Finalize func(data *Local.LocalData)	// each LP in order, after all of them have stopped
+}
+
+
+/* outcome of a run, the per-LP statistics are in Stats.Lp */
+type Results struct {
+    Started bool		// false if the setup has failed
+    Err os.Error		// why the setup or the deterministic scheduler has failed, nil if none
+    Checked bool		// run in debug mode
+    Check os.Error		// invariant violations found in debug mode, nil if none
+    Time DT.Time		// end time, or stop time if Stopped
+    Stopped bool		// stopped by Control.Stop or by the predicate of the model
+    WallClock int64		// ns from the start of the LPs to the end of the last one
+    Gvts int			// GVT evaluations
+    Rollbacks int
+    Total Stats.LPStats
+    Datas []*Local.LocalData
+}
+
 
-        manageEvent(data)
+/*
+ * runs a simulation: sets the kernel up as in config, creates and
+ * initializes the LPs, runs them (on a goroutine each, or on the calling
+ * one in deterministic mode), waits for all of them and finalizes them.
+ * The model can set the other parameters of Shared (e.g. the checkpoints)
+ * and the other packages in its Setup hook
+ */
+func Run(config *Config, model *Model) *Results {
+    res := &Results{}
+    fail := func(err os.Error) *Results {
+        res.Err = err
+        return res
+    }
+    if config.Lpnum < 1 || model.Process == nil {
+        return fail(os.NewError("at least one LP and an event handler are needed"))
+    }
 
-        if data.GvtFlag && !Gvt.CheckEvaluation() {
-            t := Gvt.GetGvt() 
-            if t != Const.ERR {
-                setGvt(t,data)
+    if config.LogLevel != "" {
+        if err := Log.SetLevel(config.LogLevel); err != nil {
+            return fail(err)
+        }
+    }
+    if config.LogFiles {
+        if err := Log.Setup(config.Lpnum); err != nil {
+            return fail(os.NewError("log files: "+err.String()))
+        }
+    }
+    Stats.Timing = config.Timing
+    Setup(config.Lpnum, config.EndTime, model.Process)
+    Shared.CommitManager = model.Commit
+    Shared.Seed = config.Seed
+    Shared.Rollbacks = config.Rollbacks
+    if config.Transport != nil {
+        if err := Communication.SetTransport(config.Transport); err != nil {
+            return fail(os.NewError("transport: "+err.String()))
+        }
+    }
+    if config.Faults != "" {
+        if err := EnableFaults(config.Faults); err != nil {
+            return fail(os.NewError("fault injection: "+err.String()))
+        }
+    }
+    if config.Check {
+        if !Check.DEBUG {
+            return fail(os.NewError("debug mode: the kernel is built without the checks, see Check.DEBUG"))
+        }
+        Check.Setup(config.Lpnum)
+    }
+    if model.Setup != nil {
+        if err := model.Setup(); err != nil {
+            return fail(err)
+        }
+    }
+    if config.Sched != "" {
+        if err := EnableSched(config.Sched); err != nil {
+            return fail(os.NewError("deterministic mode: "+err.String()))
+        }
+    }
+    if config.RealTime > 0 {
+        if err := EnableRealTime(config.RealTime, config.RealTimeWindow); err != nil {
+            return fail(os.NewError("real-time mode: "+err.String()))
+        }
+    }
+
+    /* all the LPs are initialized before starting, a resumed LP must not generate clashing ids */
+    res.Datas = make([]*Local.LocalData, config.Lpnum)
+    for i:=0;i<config.Lpnum;i++ {
+        res.Datas[i] = Initialize(DT.Pid(i))
+        if model.Init != nil {
+            if err := model.Init(res.Datas[i]); err != nil {
+                return fail(os.NewError(fmt.Sprint("LP ", i, ": ", err)))
             }
         }
+    }
+
+    res.Started = true
+    start := time.Nanoseconds()
+    if config.Sched != "" {
+        res.Err = RunScheduled(res.Datas)
+    } else {
+        done := make(chan bool)
+        for i:=0;i<config.Lpnum;i++ {
+            go func(data *Local.LocalData) {
+                Simulate(data)
+                done <- true
+            }(res.Datas[i])
+        }
+        for i:=0;i<config.Lpnum;i++ {
+            <-done
+        }
+    }
+    res.WallClock = time.Nanoseconds()-start
+    if res.Err != nil {
+        return res
+    }
+
+    if config.Check {
+        res.Checked = true
+        res.Check = Check.Final()
+    }
+    if model.Finalize != nil {
+        for i:=0;i<config.Lpnum;i++ {
+            model.Finalize(res.Datas[i])
+        }
+    }
+
+    res.Time, res.Stopped = Control.Stopped()
+    if !res.Stopped {
+        res.Time = Shared.EndTime
+    }
+    res.Gvts = Shared.N_gvt
+    for i:=0;i<config.Lpnum;i++ {
+        res.Rollbacks += Shared.N_rollback[i]
+    }
+    res.Total = Stats.Total()
+    if err := Log.Close(); err != nil {
+        Log.Error("log files not closed", "err", err)
+    }
+    return res
+}
+
+
+/* in debug mode, logs the outcome of the invariant checks. False if any has failed */
+func (res *Results) CheckReport() bool {
+    if !res.Checked {
+        return true
+    }
+    if res.Check != nil {
+        Log.Error("debug mode", "result", res.Check)
+        return false
+    }
+    Log.Info("debug mode", "result", "no invariant violations")
+    return true
+}
+
+
+/*
+ * block partitioning of n entities among lpn LPs: LP lp simulates the
+ * entities [first, first+count), the first n%lpn LPs one more than the others
+ */
+func BlockRange(lp int, n int, lpn int) (first int, count int) {
+    m := n % lpn
+    d := n / lpn
+    if lp < m {
+        return lp*(d+1), d+1
+    }
+    return m*(d+1) + (lp-m)*d, d
+}
+
+
+/* the LP of entity i in the block partitioning, see BlockRange */
+func BlockOwner(i int, n int, lpn int) int {
+    m := n % lpn
+    d := n / lpn
+    if i < m*(d+1) {
+        return i / (d+1)
+    }
+    return m + (i - m*(d+1))/d
+}
+
 
+/*
+ * a new event identifier for LP data: first is the number of identifiers
+ * taken by the initial events and next a counter in the model state. The
+ * identifiers are unique among the LPs, a re-executed event gets the same
+ * one as next is rolled back with the state (the anti-messages match the
+ * time and the entities too, see DT.Cancels). The run fails when the
+ * identifiers reach Const.INJECTIDS, the ones above are the injector's
+ */
+func NewId(first int, next *int32, data *Local.LocalData) int32 {
+    id := int64(first) + int64(*next)*int64(Shared.Lpnum) + int64(data.IndexLP)
+    if id >= Const.INJECTIDS {
+        lplog(data).Error("event identifiers exhausted", "first", first, "next", *next, "limit", Const.INJECTIDS)
+        os.Exit(1)
     }
+    *next++
+    return int32(id)
 }
 
 
@@ -96,8 +543,7 @@ func NoticeEvent(ev *DT.Event, receiver DT.Pid, data *Local.LocalData) {
 
     if receiver == data.IndexLP {
         if !data.FutureEvents.Insert(ev) {
-            fmt.Println("GO-WARP, ERROR: THE HEAP IS FULL -",len(data.FutureEvents))
-            fmt.Println(data.IndexLP,"- GO-WARP, ERROR: EVENT NOT INSERTED!")
+            lplog(data).Error("event not inserted, the heap is full", "nodes", len(data.FutureEvents), "event", ev.Id, "at", ev.Time)
             os.Exit(1)
         }
     } else {
@@ -108,6 +554,9 @@ func NoticeEvent(ev *DT.Event, receiver DT.Pid, data *Local.LocalData) {
     tm = DT.TimedMessage{*msg,data.SimTime}
 
     size := DT.Insert(tm, data.MsgSent)
+    if Check.DEBUG && Check.Enabled {
+        Check.Sorted(data, "MsgSent", data.MsgSent)
+    }
     if size > Const.TOOLARGE && Shared.State[data.IndexLP] != Const.LPEVALGVT {
         ask4NewGvt(data)
     }
@@ -115,6 +564,9 @@ func NoticeEvent(ev *DT.Event, receiver DT.Pid, data *Local.LocalData) {
 
 
 func receiveAll(data *Local.LocalData) {
+    prev := Stats.Enter(data.IndexLP, Stats.PHCOMM)
+    defer Stats.Enter(data.IndexLP, prev)
+
     Loop: for {
         msg := Communication.Receive(data.IndexLP)
 
@@ -137,12 +589,31 @@ func manageMessage(data *Local.LocalData, msg *DT.Message) {
         case Const.ABORTMSG:
         Shared.State[data.IndexLP] = Const.LPSTOPPED
 
+        case Const.CKPTMSG:
+        checkpoint(data)
+
+        case Const.PAUSEMSG:
+        pause(data)
+
+        case Const.INJECTMSG:
+        /* the injector is closed, the LP has just been woken up */
+
         case Const.ACK:
         gotAck(msg,data)
 
         default:
-        sendAck(msg,data)
+        if msg.Sender == Const.SERVERID {
+            Inject.Ack(msg.Ev.Id, data.GvtFlag)
+        } else {
+            sendAck(msg,data)
+        }
 
+        if msg.Ev.Type.Flag == Const.ANTIMSG {
+            Stats.Lp[data.IndexLP].AntiRecv++
+            if Trace.Enabled {
+                Trace.AntiMsg(data.IndexLP, false, msg.Sender, &msg.Ev)
+            }
+        }
         if checkAntimsg(&msg.Ev, data) {
             return
         }
@@ -156,7 +627,7 @@ func manageMessage(data *Local.LocalData, msg *DT.Message) {
 
         /* finally we can insert the message in the heap */
         if !(data.FutureEvents).Insert(&msg.Ev) {
-            fmt.Println(data.IndexLP,"- GO-WARP, ERROR: EVENT NOT INSERTED!")
+            lplog(data).Error("event not inserted", "from", msg.Sender, "event", msg.Ev.Id, "at", msg.Ev.Time)
             os.Exit(1)
         }
     }
@@ -168,12 +639,11 @@ func manageMessage(data *Local.LocalData, msg *DT.Message) {
  */
 func manageEvent(data *Local.LocalData) bool {
     var ev *DT.Event
-
-    data.N_PROCESSED++
+    var t0 int64
 
     t := data.FutureEvents.GetMinTime()
 
-    if t >= Shared.EndTime {
+    if t >= horizon() {
         goIdle(data)
         return false
     } else if t > data.SimTime {
@@ -183,7 +653,7 @@ func manageEvent(data *Local.LocalData) bool {
     } else if t == Const.NOTIME {
         /* heap empty */
     } else {
-        fmt.Println(data.IndexLP, "- GO-WARP, ERROR: PROCESSING AN EVENT IN THE PAST!")
+        lplog(data).Error("processing an event in the past", "at", t)
         os.Exit(1)
     }
 
@@ -191,10 +661,25 @@ func manageEvent(data *Local.LocalData) bool {
     if ev == nil { 
         return false
     }
+    data.N_PROCESSED++
+    Stats.Lp[data.IndexLP].Processed++
+
+    if Trace.Enabled {
+        t0 = Trace.Now()
+    }
+    data.SaveState(ev, Shared.Seed)
 
+    prev := Stats.Enter(data.IndexLP, Stats.PHEVENT)
     Shared.EventManager(ev, data)
+    Stats.Enter(data.IndexLP, prev)
+    if Trace.Enabled {
+        Trace.Event(data.IndexLP, t0, ev)
+    }
 
     size := DT.Insert(*ev,data.ProcessedEvents)
+    if Check.DEBUG && Check.Enabled {
+        Check.Sorted(data, "ProcessedEvents", data.ProcessedEvents)
+    }
     if size > Const.TOOLARGE && Shared.State[data.IndexLP] != Const.LPEVALGVT {
         ask4NewGvt(data)
     }
@@ -204,6 +689,17 @@ func manageEvent(data *Local.LocalData) bool {
 
 
 func rollback(t DT.Time, data *Local.LocalData){
+    var n int = 0
+    var t0 int64
+    var from DT.Time = data.SimTime
+
+    if Trace.Enabled {
+        t0 = Trace.Now()
+    }
+    if Check.DEBUG && Check.Enabled {
+        Check.Rollback(data, t)
+    }
+    prev := Stats.Enter(data.IndexLP, Stats.PHROLLBACK)
     data.SimTime = t
 
     el := data.ProcessedEvents.Back()
@@ -212,9 +708,10 @@ func rollback(t DT.Time, data *Local.LocalData){
         el = el.Prev()
         if e.Time >= data.SimTime {
             if !data.FutureEvents.Insert(&e) {
-                fmt.Println("GO-WARP, ERROR: INSERTING A PROCESSED EVENT!")
+                lplog(data).Error("rolled back event not inserted", "event", e.Id, "at", e.Time)
             }
             data.N_PROCESSED--
+            n++
         } else {
             break Loop
         }
@@ -226,6 +723,10 @@ func rollback(t DT.Time, data *Local.LocalData){
         if mp.GetTime() >= data.SimTime {
             el = el.Prev()
             anti := createAntiMessage(&mp.M)
+            Stats.Lp[data.IndexLP].AntiSent++
+            if Trace.Enabled {
+                Trace.AntiMsg(data.IndexLP, true, mp.M.Receiver, &anti.Ev)
+            }
 
             if mp.M.Receiver == data.IndexLP {
                 annihilate(&(anti.Ev), data)
@@ -239,9 +740,43 @@ func rollback(t DT.Time, data *Local.LocalData){
 
     DT.DeleteAfter(data.SimTime, data.ProcessedEvents)
     DT.DeleteAfter(data.SimTime, data.MsgSent)
+    data.RestoreState(data.SimTime)
 
     Shared.N_rollback[data.IndexLP]++
+    Stats.AddRollback(data.IndexLP, n)
+    Stats.Enter(data.IndexLP, prev)
+    if Trace.Enabled {
+        Trace.Rollback(data.IndexLP, t0, from, t, n)
+    }
+    if Log.DEBUG {
+        lplog(data).Debug("rollback", "from", from, "events", n)
+    }
+}
+
+
+/*
+ * with probability Shared.Rollbacks the LP rolls back to a random time
+ * among its processed events, that are all after the last commit. The
+ * results must not change, it tests the rollback safety of the model. Not
+ * while the local minimum of this LP is in a GVT evaluation, it could
+ * have been above the rollback time
+ */
+func forceRollback(data *Local.LocalData) {
+    rng := forced[data.IndexLP]
 
+    if rng.RandFloat() >= Shared.Rollbacks || data.GvtFlag {
+        return
+    }
+    el := data.ProcessedEvents.Front()
+    if el == nil {
+        return
+    }
+    t0 := el.Value.(DT.Event).Time
+    t := t0 + DT.Time(rng.RandFloat()*float64(data.SimTime-t0+1))
+    if t > data.SimTime {
+        t = data.SimTime
+    }
+    rollback(t, data)
 }
 
 
@@ -261,15 +796,49 @@ func sendMessage(msg *DT.Message, data *Local.LocalData) {
 func goIdle(data *Local.LocalData) {
     if Shared.State[data.IndexLP] == Const.LPSTOPPED { return }
 
+    if Shared.RealTime > 0 && clock() < bound() {
+        /* ahead of the scaled wall clock, the LP waits for it without blocking */
+        prev := Stats.Enter(data.IndexLP, Stats.PHIDLE)
+        time.Sleep(RTSLEEP)
+        Stats.Enter(data.IndexLP, prev)
+        return
+    }
+
     Shared.State[data.IndexLP] = Const.LPIDLE
     term := checkAllIdle()
-    if term { 
+    if term && bound() < Shared.EndTime {
+        if bound() == Checkpoint.Time() {
+            if Checkpoint.Start() {
+                sendAll(Const.CKPTMSG, data)
+            }
+        } else if Control.Start() {
+            sendAll(Const.PAUSEMSG, data)
+        }
+    } else if term && !Inject.Opened() {
+        /* while the injector is open the idle LPs wait for its events */
         killall(data)
         Shared.State[data.IndexLP] = Const.LPSTOPPED
     } else {
-        m := Communication.BlockingReceive(data.IndexLP)	// the process blocks indefinitively
-        
+        var t0 int64
+        if Trace.Enabled {
+            t0 = Trace.Now()
+        }
+        prev := Stats.Enter(data.IndexLP, Stats.PHIDLE)
+        m := Communication.IdleReceive(data.IndexLP, func() {	// the process blocks indefinitively
+            Shared.State[data.IndexLP] = Const.LPRUNNING
+        })
+        if m == nil {
+            /* deterministic mode, nothing to receive: the LP stays idle */
+            Stats.Enter(data.IndexLP, prev)
+            return
+        }
+        Stats.Enter(data.IndexLP, Stats.PHCOMM)
+        if Trace.Enabled {
+            Trace.Idle(data.IndexLP, t0, data.SimTime)
+        }
+
         manageMessage(data,m)
+        Stats.Enter(data.IndexLP, prev)
 
         if Shared.State[data.IndexLP] != Const.LPSTOPPED {
             Shared.State[data.IndexLP] = Const.LPRUNNING
@@ -282,7 +851,7 @@ func createAntiMessage(msg *DT.Message) *DT.Message{
     var e DT.Event
     var m DT.Message
 
-    e = *DT.CreateEvent(-msg.Ev.Id,msg.Ev.Time,DT.Info{0,0,Const.ANTIMSG})
+    e = *DT.CreateEvent(-msg.Ev.Id,msg.Ev.Time,DT.Info{msg.Ev.Type.From,msg.Ev.Type.To,Const.ANTIMSG})
     m = *DT.CreateMessage(msg.Sender, msg.Receiver, e)
 
     return &m
@@ -297,22 +866,40 @@ func annihilate(antimsg *DT.Event, data *Local.LocalData) {
         rollback(antimsg.Time,data)
     }
 
-    ev := DT.CreateEvent(-antimsg.Id,0,DT.Info{0,0,0})
-    del := data.FutureEvents.DeleteExternId(ev)
+    del := data.FutureEvents.DeleteCancelled(antimsg)
 
-    if del.Id == Const.ERR && del.Time == Const.ERR {
-        antimsg.Time = data.SimTime	// timestamping the anti-message it will be possible to rollback its reception
-            
+    deleted := del.Id != Const.ERR || del.Time != Const.ERR
+    if deleted {
+        Stats.Lp[data.IndexLP].Annihilated++
+    } else {
+        /* the positive message is still in transit: the anti-message waits for it with its own time */
         DT.Insert(*antimsg, data.AntiMsg2Annihilate)
     }
+    if Check.DEBUG && Check.Enabled {
+        Check.Annihilate(data, antimsg, deleted)
+    }
 }
 
 
+/* true if ev is cancelled by an anti-message received before it, that is deleted */
 func checkAntimsg(ev *DT.Event, data *Local.LocalData) bool {
-    m := DT.CreateMessage(0,0,*ev)
-    anti := createAntiMessage(m)
-    ret := DT.Delete(anti.Ev, data.AntiMsg2Annihilate)
-    return ret
+    if ev.Type.Flag == Const.ANTIMSG {
+        return false
+    }
+    el := data.AntiMsg2Annihilate.Front()
+    Loop: for el!=nil {
+        anti := el.Value.(DT.Event)
+        if anti.Time > ev.Time {
+            break Loop
+        }
+        if DT.Cancels(&anti, ev) {
+            data.AntiMsg2Annihilate.Remove(el)
+            Stats.Lp[data.IndexLP].Annihilated++
+            return true
+        }
+        el = el.Next()
+    }
+    return false
 }
 
 
@@ -335,6 +922,9 @@ func ask4NewGvt(data *Local.LocalData) {
 func evaluateLocalMin(data *Local.LocalData) {
     var mintime DT.Time = 1000000
 
+    prev := Stats.Enter(data.IndexLP, Stats.PHGVT)
+    defer Stats.Enter(data.IndexLP, prev)
+
     Shared.State[data.IndexLP] = Const.LPEVALGVT
  
     /* mintime computation and communication */
@@ -363,33 +953,112 @@ func setGvt(gvt DT.Time, data *Local.LocalData){
 
     if Shared.State[data.IndexLP] == Const.LPSTOPPED { return }
 
+    if Check.DEBUG && Check.Enabled {
+        Check.SetGvt(data, gvt)
+    }
     if gvt < data.Gvt {
-        fmt.Println(data.IndexLP,", GO-WARP, ERROR: THE NEW GVT VALUE IS LOWER THAN THE PREVIOUS ONE!")
+        lplog(data).Error("the new GVT is lower than the previous one", "new", gvt)
         os.Exit(1)
     }
+    prev := Stats.Enter(data.IndexLP, Stats.PHGVT)
     data.GvtFlag = false
     data.Gvt = gvt
+    Stats.Lp[data.IndexLP].GvtRounds++
+    if Trace.Enabled {
+        Trace.Gvt(data.IndexLP, gvt)
+    }
+    if Log.DEBUG {
+        lplog(data).Debug("new GVT", "pending", data.FutureEvents.Len(), "processed", data.ProcessedEvents.Len())
+    }
 
-    fossilCollection(gvt, data)
+    t := gvt
+    if Shared.RealTime > 0 {
+        /* the output is released when the scaled wall clock reaches it */
+        if c := clock(); c < t {
+            t = c
+        }
+    }
+    fossilCollection(t, data)
+    if Stats.Live {
+        publish(data)
+    }
+    Control.Committed(gvt)
+    Stats.Enter(data.IndexLP, prev)
 }
 
 
+/*
+ * only what is strictly before the GVT is committed and released: the GVT
+ * is the minimum unprocessed timestamp, so a straggler or an anti-message
+ * can still carry timestamp t and roll back the events processed at t
+ */
 func fossilCollection(t DT.Time, data *Local.LocalData) {
-    DT.DeleteBefore(t, data.ProcessedEvents)
-    DT.DeleteBefore(t, data.MsgSent)
+    commit(t, data)
+    DT.DeleteBefore(t-1, data.MsgSent)
     Shared.State[data.IndexLP] = Const.LPRUNNING
 
     data.Acked.Init()
 }
 
 
+/*
+ * publishes the statistics read by the live metrics, once per GVT: the
+ * pending events are counted over the whole heap
+ */
+func publish(data *Local.LocalData) {
+    Stats.Publish(data.IndexLP, data.SimTime, data.FutureEvents.Len(), data.HistoryBytes())
+}
+
+
+/*
+ * the processed events with time < t are committed and released, the commit
+ * hook of the model gets each of them with the state saved before it
+ */
+func commit(t DT.Time, data *Local.LocalData) {
+    var n int = 0
+    var evs []DT.Event
+
+    el := data.ProcessedEvents.Front()
+    for el != nil && el.Value.(DT.Event).Time < t {
+        n++
+        el = el.Next()
+    }
+    if CommitLog.Enabled {
+        evs = make([]DT.Event, n)
+    }
+
+    sel := data.States.Front()
+    for i:=0;i<n;i++ {
+        el = data.ProcessedEvents.Front()
+        ev := el.Value.(DT.Event)
+        if evs != nil {
+            evs[i] = ev
+        }
+        if Shared.CommitManager != nil && sel != nil {
+            Shared.CommitManager(&ev, sel.Value.(State.State).LpVar, data)
+            sel = sel.Next()
+        }
+        data.ProcessedEvents.Remove(el)
+    }
+    if evs != nil {
+        CommitLog.Write(data.IndexLP, evs)
+    }
+    DT.DeleteBefore(t-1, data.States)
+    Stats.Lp[data.IndexLP].Committed += int64(n)
+    if Check.DEBUG && Check.Enabled {
+        Check.Commit(data, t)
+    }
+}
+
+
 func sendAck(msg *DT.Message, data *Local.LocalData) {
     var e *DT.Event
 
+    /* the time of the message is in From, its identifier may be reused by a re-executed event */
     if data.GvtFlag {
-        e = DT.CreateEvent(msg.Ev.Id,Const.ACK,DT.Info{0,0,Const.YOURS})
+        e = DT.CreateEvent(msg.Ev.Id,Const.ACK,DT.Info{int(msg.Ev.Time),0,Const.YOURS})
     } else {
-        e = DT.CreateEvent(msg.Ev.Id,Const.ACK,DT.Info{0,0,Const.MINE})
+        e = DT.CreateEvent(msg.Ev.Id,Const.ACK,DT.Info{int(msg.Ev.Time),0,Const.MINE})
     }
     ack := DT.CreateMessage(msg.Receiver, msg.Sender, *e)
     Communication.Send(ack)
@@ -402,14 +1071,14 @@ func gotAck(msg *DT.Message,data *Local.LocalData) {
     el := data.OutgoingMsg.Front()
     Loop: for el != nil {
         m := el.Value.(DT.TimedMessage)
-        if m.M.Receiver == msg.Sender && m.M.Ev.Id == msg.Ev.Id && m.M.Ev.Time != Const.ACK { 
+        if m.M.Receiver == msg.Sender && m.M.Ev.Id == msg.Ev.Id && m.M.Ev.Time == DT.Time(msg.Ev.Type.From) { 
             if msg.Ev.Type.Flag == Const.MINE {
                 data.OutgoingMsg.Remove(el)
             } else if msg.Ev.Type.Flag == Const.YOURS {
                 DT.Insert(m, data.Acked)
                 data.OutgoingMsg.Remove(el)
             } else {
-                fmt.Println(data.IndexLP,"- GO-WARP, ERROR: UNKNOWN FLAG TYPE")
+                lplog(data).Error("unknown ACK type", "flag", msg.Ev.Type.Flag)
                 os.Exit(1)
             }
             found = true
@@ -417,26 +1086,156 @@ func gotAck(msg *DT.Message,data *Local.LocalData) {
         }
         el = el.Next()
     }
-    if !found { fmt.Println("GO-WARP, ERROR: WHAT ABOUT THIS ACK?") }
+    if !found { lplog(data).Warn("ACK of an unknown message", "from", msg.Sender, "event", msg.Ev.Id) }
 
 }
 
 
+/*
+ * true if all the LPs are idle and no message is in transit, the counters
+ * are read before and after the states to be sure that nothing has moved.
+ * An idle LP leaves the idle state before its message is counted as
+ * received (see Communication.IdleReceive), so the message is either in
+ * transit or held by a running LP
+ */
 func checkAllIdle() bool {
     var ret bool = true
+
+    sent1,recv1 := Communication.Count()
     Loop: for i:=0;i<Shared.Lpnum;i++ {
         if Shared.State[i] != Const.LPIDLE {
             ret = false
             break Loop
         }
     }
-    return ret
+    sent2,recv2 := Communication.Count()
+
+    return ret && sent1 == recv1 && sent1 == sent2 && recv1 == recv2
+}
+
+
+/* events up to this time can be processed */
+func horizon() DT.Time {
+    h := bound()
+    if Shared.RealTime > 0 {
+        if c := clock()+Shared.RealTimeWindow; c < h {
+            h = c
+        }
+    }
+    return h
+}
+
+
+/* the horizon without the limit of the real-time mode */
+func bound() DT.Time {
+    h := Shared.EndTime
+    if c := Checkpoint.Time(); c > 0 && c < h {
+        h = c
+    }
+    if t := Control.Time(); t > 0 && t < h {
+        h = t
+    }
+    return h
+}
+
+
+/*
+ * all the LPs are idle at the checkpoint time: every event before it is
+ * committed, the local state is saved and the LP waits for the others
+ */
+func checkpoint(data *Local.LocalData) {
+    t := Checkpoint.Time()
+
+    commit(t, data)
+    DT.DeleteBefore(t-1, data.MsgSent)
+
+    if err := Checkpoint.Save(Shared.CheckpointFile, t, data); err != nil {
+        lplog(data).Error("checkpoint not saved", "err", err)
+        os.Exit(1)
+    }
+    Checkpoint.Wait()
+
+    if Shared.State[data.IndexLP] != Const.LPSTOPPED {
+        Shared.State[data.IndexLP] = Const.LPRUNNING
+    }
+}
+
+
+/*
+ * all the LPs are idle at the pause time: every event before it is
+ * committed and the LP waits until the simulation is resumed. If the run
+ * is stopped the LP does not wait (or is released) and stops there
+ */
+func pause(data *Local.LocalData) {
+    t := Control.Time()
+
+    commit(t, data)
+    DT.DeleteBefore(t-1, data.MsgSent)
+
+    /* running while paused, nobody must find all the LPs idle after the resume */
+    if Shared.State[data.IndexLP] != Const.LPSTOPPED {
+        Shared.State[data.IndexLP] = Const.LPRUNNING
+    }
+    prev := Stats.Enter(data.IndexLP, Stats.PHIDLE)
+    stop := Control.Wait()
+    Stats.Enter(data.IndexLP, prev)
+
+    if stop {
+        discard(t, data)
+        Shared.State[data.IndexLP] = Const.LPSTOPPED
+        if data.IndexLP == 0 {
+            Inject.End()
+        }
+    }
+}
+
+
+/*
+ * the run stops at t: the events processed after it are undone without
+ * anti-messages, every LP undoes its own and nothing is in transit
+ */
+func discard(t DT.Time, data *Local.LocalData) {
+    var n int = 0
+
+    if Check.DEBUG && Check.Enabled {
+        Check.Rollback(data, t)
+    }
+    for el:=data.ProcessedEvents.Back(); el!=nil && el.Value.(DT.Event).Time>=t; el=el.Prev() {
+        n++
+    }
+    DT.DeleteAfter(t, data.ProcessedEvents)
+    DT.DeleteAfter(t, data.MsgSent)
+    data.RestoreState(t)
+    data.N_PROCESSED -= n
+    Stats.Lp[data.IndexLP].RolledBack += int64(n)
+    if data.SimTime > t {
+        data.SimTime = t
+    }
+}
+
+
+/* the logger of LP data, with its simulated time and GVT */
+func lplog(data *Local.LocalData) *Log.Logger {
+    return Log.LP(data.IndexLP, data.SimTime, data.Gvt)
+}
+
+
+/* sends a control message to all the LPs, itself included */
+func sendAll(t DT.Time, data *Local.LocalData) {
+    ev := DT.CreateEvent(0,t,DT.Info{0,0,0})
+
+    for i:=0;i<Shared.Lpnum;i++ {
+            m := DT.CreateMessage(data.IndexLP,DT.Pid(i),*ev)
+            Communication.Send(m)
+    }
 }
 
 
 func killall(data *Local.LocalData) {
     ev := DT.CreateEvent(0,Const.ABORTMSG,DT.Info{0,0,0})
 
+    Control.End()
+
     for i:=0;i<Shared.Lpnum;i++ {
             m := DT.CreateMessage(data.IndexLP,DT.Pid(i),*ev)
             Communication.Send(m)
@@ -13,20 +13,28 @@
 
 package State
 
+/*
+ * what an LP saves before processing an event: the model state and the
+ * random number stream used by the event
+ */
+
 import(
     "./DT"
+    "./Random"
 )
 
 type State struct {
     SimTime DT.Time
     LpVar DT.LPstate
+    Entity int			// owner of the stream
+    Rng Random.RNG
 }
 
 
-func CreateState(time DT.Time, lpvar DT.LPstate) *State {
+func CreateState(time DT.Time, lpvar DT.LPstate, entity int, rng Random.RNG) *State {
     var state *State = new(State)
 
-    *state = State{time,lpvar}
+    *state = State{time,lpvar,entity,rng}
     return state
 }
 
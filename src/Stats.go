@@ -0,0 +1,286 @@
+/*
+	GO-WARP: a Time Warp simulator written in Go
+	http://pads.cs.unibo.it
+  
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+
+package Stats
+
+/*
+ * Per-LP statistics collected by the kernel, they are written in JSON
+ * and CSV format at the end of the run
+ */
+
+import(
+    "./DT"
+    "./Const"
+    "fmt"
+    "os"
+    "bufio"
+    "sync"
+    "time"
+)
+
+/* kernel phases whose wall clock time is measured, they never overlap */
+const(
+    PHKERNEL = iota	// everything not listed below (main loop, heap, lists)
+    PHEVENT = iota	// event processing (model code)
+    PHROLLBACK = iota	// rollbacks and anti-messages generation
+    PHGVT = iota	// local minimum evaluation and fossil collection
+    PHCOMM = iota	// receiving and managing the incoming messages
+    PHIDLE = iota	// blocked waiting for a message
+    NPHASES = iota
+)
+
+/*
+ * rollback length histogram: bucket 0 counts the rollbacks that undo no
+ * events, bucket i the ones undoing [2^(i-1), 2^i) events, the last bucket
+ * counts all the longer rollbacks
+ */
+const HISTLEN = 16
+
+var phaseNames = [NPHASES]string{"kernel", "event", "rollback", "gvt", "comm", "idle"}
+
+type LPStats struct {
+    Processed int64		// executed events, re-executions included
+    Committed int64		// events that can no longer be rolled back
+    RolledBack int64		// undone events
+    Rollbacks int64
+    RollbackLen [HISTLEN]int64
+    AntiSent int64
+    AntiRecv int64
+    Annihilated int64
+    GvtRounds int64
+    PhaseTime [NPHASES]int64	// nanoseconds, measured only if Timing is set
+
+    /* gauges, they are updated only by Publish */
+    SimTime DT.Time
+    Pending int			// events in the pending event set
+    HistoryBytes int64		// memory held in the history lists
+
+    phase int			// current phase
+    since int64			// when the current phase started
+}
+
+var(
+    Lp []LPStats
+    Live bool = false		// if true the LPs publish their statistics at each GVT
+    Timing bool = false		// if true Enter measures the wall clock time of the phases
+    lock sync.Mutex
+    published []LPStats		// the last published copy of Lp, read by the live metrics
+)
+
+
+func Setup(lpn int) {
+    Lp = make([]LPStats, lpn)
+    published = make([]LPStats, lpn)
+
+    var now int64 = 0
+    if Timing {
+        now = time.Nanoseconds()
+    }
+    for i:=0;i<lpn;i++ {
+        Lp[i].phase = PHKERNEL
+        Lp[i].since = now
+    }
+}
+
+
+/*
+ * LP pid enters a new phase, with Timing the time elapsed since the last
+ * call is charged to the phase that ends. Returns the previous phase, so
+ * that nested phases can be closed by entering it again
+ */
+func Enter(pid DT.Pid, phase int) int {
+    s := &Lp[pid]
+
+    prev := s.phase
+    s.phase = phase
+    if Timing {
+        now := time.Nanoseconds()
+        s.PhaseTime[prev] += now - s.since
+        s.since = now
+    }
+
+    return prev
+}
+
+
+/* a rollback of LP pid has undone n events */
+func AddRollback(pid DT.Pid, n int) {
+    var b int = 0
+
+    for i:=n; i>0 && b<HISTLEN-1; i>>=1 {
+        b++
+    }
+    Lp[pid].Rollbacks++
+    Lp[pid].RolledBack += int64(n)
+    Lp[pid].RollbackLen[b]++
+}
+
+
+/*
+ * LP pid publishes a copy of its statistics with the gauges, so that they
+ * can be read while it runs. Called by the LP itself
+ */
+func Publish(pid DT.Pid, simtime DT.Time, pending int, history int64) {
+    s := &Lp[pid]
+    s.SimTime = simtime
+    s.Pending = pending
+    s.HistoryBytes = history
+
+    lock.Lock()
+    published[pid] = *s
+    lock.Unlock()
+}
+
+
+/* the last published statistics of every LP, it can be called from any goroutine */
+func Snapshot() []LPStats {
+    lock.Lock()
+    defer lock.Unlock()
+
+    lps := make([]LPStats, len(published))
+    copy(lps, published)
+    return lps
+}
+
+
+/* ratio between committed and processed events */
+func (s *LPStats) Efficiency() float64 {
+    if s.Processed == 0 {
+        return 0
+    }
+    return float64(s.Committed)/float64(s.Processed)
+}
+
+
+/* sums the statistics of all the LPs */
+func Total() LPStats {
+    return Sum(Lp)
+}
+
+
+/* sums the statistics in lps, e.g. a Snapshot */
+func Sum(lps []LPStats) LPStats {
+    var tot LPStats
+
+    for i:=0;i<len(lps);i++ {
+        s := &lps[i]
+        tot.Processed += s.Processed
+        tot.Committed += s.Committed
+        tot.RolledBack += s.RolledBack
+        tot.Rollbacks += s.Rollbacks
+        tot.AntiSent += s.AntiSent
+        tot.AntiRecv += s.AntiRecv
+        tot.Annihilated += s.Annihilated
+        tot.GvtRounds += s.GvtRounds
+        for j:=0;j<HISTLEN;j++ {
+            tot.RollbackLen[j] += s.RollbackLen[j]
+        }
+        for j:=0;j<NPHASES;j++ {
+            tot.PhaseTime[j] += s.PhaseTime[j]
+        }
+    }
+    return tot
+}
+
+
+func create(filename string) (*os.File, os.Error) {
+    return os.Open(filename, os.O_WRONLY|os.O_CREAT|os.O_TRUNC, Const.PERM)
+}
+
+
+func writeJSONLP(w *bufio.Writer, s *LPStats) {
+    fmt.Fprintf(w, "{\"processed\": %d, \"committed\": %d, \"rolled_back\": %d, ", s.Processed, s.Committed, s.RolledBack)
+    fmt.Fprintf(w, "\"efficiency\": %.6f, \"rollbacks\": %d, ", s.Efficiency(), s.Rollbacks)
+    fmt.Fprintf(w, "\"anti_sent\": %d, \"anti_recv\": %d, \"annihilated\": %d, ", s.AntiSent, s.AntiRecv, s.Annihilated)
+    fmt.Fprintf(w, "\"gvt_rounds\": %d, \"rollback_len\": [", s.GvtRounds)
+    for i:=0;i<HISTLEN;i++ {
+        if i > 0 { fmt.Fprint(w, ", ") }
+        fmt.Fprint(w, s.RollbackLen[i])
+    }
+    fmt.Fprint(w, "], \"phase_ns\": {")
+    for i:=0;i<NPHASES;i++ {
+        if i > 0 { fmt.Fprint(w, ", ") }
+        fmt.Fprintf(w, "\"%s\": %d", phaseNames[i], s.PhaseTime[i])
+    }
+    fmt.Fprint(w, "}}")
+}
+
+
+/* writes all the statistics in JSON format, wallclock is in nanoseconds */
+func WriteJSON(filename string, wallclock int64, ngvt int) os.Error {
+    file,err := create(filename)
+    if err != nil {
+        return err
+    }
+    w := bufio.NewWriter(file)
+
+    tot := Total()
+    fmt.Fprintf(w, "{\n  \"lpnum\": %d,\n  \"wallclock_ns\": %d,\n  \"gvt_evaluations\": %d,\n", len(Lp), wallclock, ngvt)
+    fmt.Fprint(w, "  \"total\": ")
+    writeJSONLP(w, &tot)
+    fmt.Fprint(w, ",\n  \"lps\": [\n")
+    for i:=0;i<len(Lp);i++ {
+        fmt.Fprint(w, "    ")
+        writeJSONLP(w, &Lp[i])
+        if i < len(Lp)-1 { fmt.Fprint(w, ",") }
+        fmt.Fprint(w, "\n")
+    }
+    fmt.Fprint(w, "  ]\n}\n")
+
+    err = w.Flush()
+    file.Close()
+    return err
+}
+
+
+func writeCSVLP(w *bufio.Writer, name string, s *LPStats) {
+    fmt.Fprintf(w, "%s,%d,%d,%d,%.6f,%d,%d,%d,%d,%d", name, s.Processed, s.Committed, s.RolledBack,
+        s.Efficiency(), s.Rollbacks, s.AntiSent, s.AntiRecv, s.Annihilated, s.GvtRounds)
+    for i:=0;i<NPHASES;i++ {
+        fmt.Fprintf(w, ",%d", s.PhaseTime[i])
+    }
+    for i:=0;i<HISTLEN;i++ {
+        fmt.Fprintf(w, ",%d", s.RollbackLen[i])
+    }
+    fmt.Fprint(w, "\n")
+}
+
+
+/* writes the statistics in CSV format, one row per LP plus the totals */
+func WriteCSV(filename string) os.Error {
+    file,err := create(filename)
+    if err != nil {
+        return err
+    }
+    w := bufio.NewWriter(file)
+
+    fmt.Fprint(w, "lp,processed,committed,rolled_back,efficiency,rollbacks,anti_sent,anti_recv,annihilated,gvt_rounds")
+    for i:=0;i<NPHASES;i++ {
+        fmt.Fprintf(w, ",%s_ns", phaseNames[i])
+    }
+    for i:=0;i<HISTLEN;i++ {
+        fmt.Fprintf(w, ",rblen_%d", i)
+    }
+    fmt.Fprint(w, "\n")
+
+    for i:=0;i<len(Lp);i++ {
+        writeCSVLP(w, fmt.Sprint(i), &Lp[i])
+    }
+    tot := Total()
+    writeCSVLP(w, "total", &tot)
+
+    err = w.Flush()
+    file.Close()
+    return err
+}
@@ -0,0 +1,168 @@
+/*
+	GO-WARP: a Time Warp simulator written in Go
+	http://pads.cs.unibo.it
+  
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+
+package Trace
+
+/*
+ * Opt-in execution tracer: every event execution, rollback, anti-message,
+ * GVT round and idle period of each LP is written in the Chrome trace-event
+ * JSON format (chrome://tracing, ui.perfetto.dev). Each LP writes its records
+ * in a temporary file under Const.LOGDIR, the files are merged by Close
+ */
+
+import(
+    "./Const"
+    "./DT"
+    "fmt"
+    "os"
+    "io"
+    "bufio"
+    "time"
+)
+
+var(
+    Enabled bool = false
+    filename string
+    startT int64
+    files []*os.File
+    out []*bufio.Writer
+)
+
+
+func tmpName(pid int) string {
+    return fmt.Sprintf("%strace.%d.tmp", Const.LOGDIR, pid)
+}
+
+
+/* enables the tracer, the trace will be written in fname by Close */
+func Setup(lpn int, fname string) os.Error {
+    var err os.Error
+
+    filename = fname
+    startT = time.Nanoseconds()
+    files = make([]*os.File, lpn)
+    out = make([]*bufio.Writer, lpn)
+
+    for i:=0;i<lpn;i++ {
+        files[i],err = os.Open(tmpName(i), os.O_RDWR|os.O_CREAT|os.O_TRUNC, Const.PERM)
+        if err != nil {
+            return err
+        }
+        out[i] = bufio.NewWriter(files[i])
+    }
+    Enabled = true
+    return nil
+}
+
+
+/* returns the current time, to be used as start of a duration record */
+func Now() int64 {
+    return time.Nanoseconds()
+}
+
+
+/* microseconds since the start of the trace */
+func us(t int64) float64 {
+    return float64(t - startT)/1e3
+}
+
+
+func complete(pid DT.Pid, name string, start int64, args string) {
+    now := time.Nanoseconds()
+    fmt.Fprintf(out[pid], "{\"name\": \"%s\", \"ph\": \"X\", \"pid\": 0, \"tid\": %d, \"ts\": %.3f, \"dur\": %.3f, \"args\": {%s}},\n",
+        name, pid, us(start), float64(now - start)/1e3, args)
+}
+
+
+func instant(pid DT.Pid, name string, args string) {
+    fmt.Fprintf(out[pid], "{\"name\": \"%s\", \"ph\": \"i\", \"s\": \"t\", \"pid\": 0, \"tid\": %d, \"ts\": %.3f, \"args\": {%s}},\n",
+        name, pid, us(time.Nanoseconds()), args)
+}
+
+
+/* execution of ev, started at wall clock time start */
+func Event(pid DT.Pid, start int64, ev *DT.Event) {
+    complete(pid, "event", start, fmt.Sprintf("\"simtime\": %d, \"id\": %d, \"from\": %d, \"to\": %d, \"flag\": %d",
+        ev.Time, ev.Id, ev.Type.From, ev.Type.To, ev.Type.Flag))
+}
+
+
+/* rollback from simulated time from back to time to, n events undone */
+func Rollback(pid DT.Pid, start int64, from DT.Time, to DT.Time, n int) {
+    complete(pid, "rollback", start, fmt.Sprintf("\"from\": %d, \"to\": %d, \"undone\": %d", from, to, n))
+}
+
+
+/* anti-message sent (or received if sent is false) */
+func AntiMsg(pid DT.Pid, sent bool, other DT.Pid, ev *DT.Event) {
+    var name string = "antimsg-recv"
+
+    if sent {
+        name = "antimsg-sent"
+    }
+    instant(pid, name, fmt.Sprintf("\"lp\": %d, \"simtime\": %d, \"id\": %d", other, ev.Time, -ev.Id))
+}
+
+
+func Gvt(pid DT.Pid, gvt DT.Time) {
+    instant(pid, "gvt", fmt.Sprintf("\"gvt\": %d", gvt))
+}
+
+
+/* LP blocked waiting for a message since start */
+func Idle(pid DT.Pid, start int64, simtime DT.Time) {
+    complete(pid, "idle", start, fmt.Sprintf("\"simtime\": %d", simtime))
+}
+
+
+/* merges the records of all the LPs in the trace file */
+func Close() os.Error {
+    if !Enabled {
+        return nil
+    }
+    Enabled = false
+
+    file,err := os.Open(filename, os.O_WRONLY|os.O_CREAT|os.O_TRUNC, Const.PERM)
+    if err != nil {
+        return err
+    }
+    w := bufio.NewWriter(file)
+    fmt.Fprint(w, "{\"displayTimeUnit\": \"ms\", \"traceEvents\": [\n")
+
+    for i:=0;i<len(files);i++ {
+        out[i].Flush()
+        files[i].Seek(0, 0)
+        _,err = io.Copy(w, files[i])
+        files[i].Close()
+        os.Remove(tmpName(i))
+        if err != nil {
+            file.Close()
+            return err
+        }
+    }
+
+    /* thread names, the last record has no trailing comma */
+    for i:=0;i<len(files);i++ {
+        fmt.Fprintf(w, "{\"name\": \"thread_name\", \"ph\": \"M\", \"pid\": 0, \"tid\": %d, \"args\": {\"name\": \"LP %d\"}}", i, i)
+        if i < len(files)-1 {
+            fmt.Fprint(w, ",")
+        }
+        fmt.Fprint(w, "\n")
+    }
+    fmt.Fprint(w, "]}\n")
+
+    err = w.Flush()
+    file.Close()
+    return err
+}
@@ -0,0 +1,231 @@
+/*
+	GO-WARP: a Time Warp simulator written in Go
+	http://pads.cs.unibo.it
+
+	This file is part of GO-WARP.  GO-WARP is free software, you can
+	redistribute it and/or modify it under the terms of the Revised BSD License.
+
+	For more information please see the LICENSE file.
+
+	Copyright 2014, Gabriele D'Angelo, Moreno Marzolla, Pietro Ansaloni
+	Computer Science Department, University of Bologna, Italy
+*/
+
+
+package Workload
+
+/*
+ * Trace-driven workloads: the initial events are read from a file instead
+ * of being generated by the model. Each line is an event, in CSV
+ *     time,entity,type[,payload]
+ * (a first line that does not start with a number is a header) or in JSON
+ *     {"time": 10, "entity": 3, "type": 1, "payload": ...}
+ * the two formats can be mixed, empty lines and lines starting with # are
+ * skipped. The events get the identifiers [0, n) in file order, the
+ * destination entity goes in Type.To, the type in Type.Flag and Type.From
+ * is Const.SERVERID. The payloads are kept here, the model reads them with
+ * Payload. The events are split across the LPs with Shared.EntityMap
+ */
+
+import(
+    "./Const"
+    "./DT"
+    "./Local"
+    "./Shared"
+    "fmt"
+    "os"
+    "bufio"
+    "json"
+    "strings"
+    "strconv"
+)
+
+var(
+    events []DT.Event
+    payloads []string		// by event identifier
+)
+
+
+/*
+ * reads the initial events from filename, after Sim.Setup and before the
+ * LPs are initialized. The model must set Shared.EntityMap. Returns the
+ * number of events
+ */
+func Load(filename string) (int, os.Error) {
+    if Shared.EntityMap == nil {
+        return 0, os.NewError("the model does not support the trace-driven workloads")
+    }
+    file,err := os.Open(filename, os.O_RDONLY, 0)
+    if err != nil {
+        return 0, err
+    }
+    defer file.Close()
+    rd := bufio.NewReader(file)
+
+    events = make([]DT.Event, 0, 1024)
+    payloads = make([]string, 0, 1024)
+    first := true
+    for n:=1;;n++ {
+        line,err := rd.ReadString('\n')
+        if err == os.EOF && len(line) == 0 {
+            break
+        } else if err != nil && err != os.EOF {
+            return 0, err
+        }
+        line = strings.TrimSpace(line)
+        if len(line) == 0 || line[0] == '#' {
+            continue
+        }
+
+        var ev DT.Event
+        var payload string
+        if line[0] == '{' {
+            ev,payload,err = parseJSON(line)
+        } else {
+            ev,payload,err = parseCSV(line)
+            if err != nil && first && !startsWithNumber(line) {
+                /* header */
+                first = false
+                continue
+            }
+        }
+        first = false
+        if err == nil && ev.Time < 0 {
+            err = os.NewError(fmt.Sprint("negative time ", ev.Time))
+        }
+        if err == nil && ev.Time >= Shared.EndTime {
+            err = os.NewError(fmt.Sprint("time ", ev.Time, " is after the end of the simulation"))
+        }
+        if lp := Shared.EntityMap(ev.Type.To); err == nil && (lp < 0 || int(lp) >= Shared.Lpnum) {
+            err = os.NewError(fmt.Sprint("unknown entity ", ev.Type.To))
+        }
+        if err != nil {
+            return 0, os.NewError(fmt.Sprint(filename, " line ", n, ": ", err))
+        }
+        ev.Id = int32(len(events))
+        ev.Type.From = Const.SERVERID
+        add(ev, payload)
+    }
+    return len(events), nil
+}
+
+
+func add(ev DT.Event, payload string) {
+    if len(events) == cap(events) {
+        e1 := make([]DT.Event, len(events), 2*cap(events))
+        copy(e1, events)
+        events = e1
+        p1 := make([]string, len(payloads), 2*cap(payloads))
+        copy(p1, payloads)
+        payloads = p1
+    }
+    events = events[0:len(events)+1]
+    events[len(events)-1] = ev
+    payloads = payloads[0:len(payloads)+1]
+    payloads[len(payloads)-1] = payload
+}
+
+
+func startsWithNumber(line string) bool {
+    f := strings.Split(line, ",", 2)
+    _,err := strconv.Atoi(strings.TrimSpace(f[0]))
+    return err == nil
+}
+
+
+/* time,entity,type[,payload], the payload is the rest of the line and can be quoted */
+func parseCSV(line string) (ev DT.Event, payload string, err os.Error) {
+    f := strings.Split(line, ",", 4)
+    if len(f) < 3 {
+        return ev, "", os.NewError("time,entity,type[,payload] expected")
+    }
+    var t, entity, typ int
+    if t,err = strconv.Atoi(strings.TrimSpace(f[0])); err != nil {
+        return
+    }
+    if entity,err = strconv.Atoi(strings.TrimSpace(f[1])); err != nil {
+        return
+    }
+    if typ,err = strconv.Atoi(strings.TrimSpace(f[2])); err != nil {
+        return
+    }
+    if len(f) == 4 {
+        payload = strings.TrimSpace(f[3])
+        if len(payload) >= 2 && payload[0] == '"' && payload[len(payload)-1] == '"' {
+            payload = strings.Replace(payload[1:len(payload)-1], "\"\"", "\"", -1)
+        }
+    }
+    ev.Time = DT.Time(t)
+    ev.Type.To = entity
+    ev.Type.Flag = int32(typ)
+    return
+}
+
+
+/* a JSON object with time, entity, type (default 0) and payload (optional, any value) */
+func parseJSON(line string) (ev DT.Event, payload string, err os.Error) {
+    var m map[string]interface{}
+    if err = json.Unmarshal([]byte(line), &m); err != nil {
+        return
+    }
+    num := func(key string, required bool) int {
+        v,ok := m[key]
+        if !ok {
+            if required && err == nil {
+                err = os.NewError(key+" missing")
+            }
+            return 0
+        }
+        x,ok := v.(float64)
+        if !ok && err == nil {
+            err = os.NewError(key+" is not a number")
+        }
+        return int(x)
+    }
+    ev.Time = DT.Time(num("time", true))
+    ev.Type.To = num("entity", true)
+    ev.Type.Flag = int32(num("type", false))
+
+    switch v := m["payload"].(type) {
+        case nil:
+        case string:
+        payload = v
+        default:
+        var b []byte
+        if b,err = json.Marshal(v); err == nil {
+            payload = string(b)
+        }
+    }
+    return
+}
+
+
+/*
+ * inserts the events of the entities of LP data in its pending events,
+ * returns an error if the pending events do not take one of them
+ */
+func Insert(data *Local.LocalData) os.Error {
+    for i:=0;i<len(events);i++ {
+        if Shared.EntityMap(events[i].Type.To) == data.IndexLP {
+            if !data.FutureEvents.Insert(&events[i]) {
+                return os.NewError(fmt.Sprint("event ", events[i].Id, " at time ", events[i].Time, " not inserted"))
+            }
+        }
+    }
+    return nil
+}
+
+
+/* number of loaded events, the identifiers of the model must start from here */
+func Len() int {
+    return len(events)
+}
+
+
+/* payload of a loaded event, empty for the events generated by the model */
+func Payload(ev *DT.Event) string {
+    if ev.Type.From != Const.SERVERID || ev.Id < 0 || int(ev.Id) >= len(payloads) {
+        return ""
+    }
+    return payloads[ev.Id]
+}